@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// adoptedContainer records a container which was not started by the
+// dockermanager but matched its configuration. As labels can not be
+// added to existing containers the record replaces them.
+type adoptedContainer struct {
+	Name       string    `json:"name"`
+	ConfigName string    `json:"config_name"`
+	Replica    int       `json:"replica,omitempty"`
+	Checksum   string    `json:"checksum"`
+	AdoptedAt  time.Time `json:"adopted_at"`
+}
+
+type adoptedContainers map[string]adoptedContainer
+
+func loadAdoptedContainers(filename string) (adoptedContainers, error) {
+	adopted := adoptedContainers{}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return adopted, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read adopted containers: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &adopted); err != nil {
+		return nil, fmt.Errorf("Unable to parse adopted containers: %s", err)
+	}
+
+	return adopted, nil
+}
+
+func (a adoptedContainers) save(filename string) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal adopted containers: %s", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Unable to create state dir: %s", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write adopted containers: %s", err)
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// EnableAdoption lets the scheduler take over running containers
+// without dockermanager labels if their settings match the config.
+// Adopted containers are stored in the given file.
+func (s *scheduler) EnableAdoption(filename string) error {
+	adopted, err := loadAdoptedContainers(filename)
+	if err != nil {
+		return err
+	}
+
+	s.lock(lockAdoption, true)
+	s.adopted = adopted
+	s.adoptedFile = filename
+	s.adoptionReported = map[string]string{}
+	s.unlock(lockAdoption, true)
+
+	// Apply the records to the already collected containers
+	s.lock(lockContainers, true)
+	defer s.unlock(lockContainers, true)
+	for id, cont := range s.knownContainers {
+		s.knownContainers[id] = s.applyAdoption(cont)
+	}
+
+	return nil
+}
+
+// applyAdoption marks the container as managed if it was adopted
+func (s *scheduler) applyAdoption(c container) container {
+	s.lock(lockAdoption, false)
+	defer s.unlock(lockAdoption, false)
+
+	a, ok := s.adopted[c.Container.ID]
+	if !ok || c.IsManaged {
+		return c
+	}
+
+	c.IsManaged = true
+	c.Checksum = a.Checksum
+	c.ConfigName = a.ConfigName
+	c.Replica = a.Replica
+	return c
+}
+
+// forgetAdoption removes the record of a removed container
+func (s *scheduler) forgetAdoption(id string) {
+	s.lock(lockAdoption, true)
+	defer s.unlock(lockAdoption, true)
+
+	delete(s.adoptionReported, id)
+
+	if _, ok := s.adopted[id]; !ok {
+		return
+	}
+
+	delete(s.adopted, id)
+	if err := s.adopted.save(s.adoptedFile); err != nil {
+		log.Errorf("Unable to save adopted containers: %s", err)
+	}
+}
+
+// adoptContainers compares running containers without dockermanager
+// labels having the name of a configured container to the settings
+// they would be created with. Equivalent containers are adopted, for
+// the others the differences are reported.
+func (s *scheduler) adoptContainers() {
+	if s.adoptedFile == "" {
+		return
+	}
+
+	s.lock(lockConfig, false)
+	defer s.unlock(lockConfig, false)
+	s.lock(lockContainers, true)
+	defer s.unlock(lockContainers, true)
+
+	for id, cont := range s.knownContainers {
+		if cont.IsManaged || cont.IsScheduled || !cont.Container.State.Running {
+			continue
+		}
+
+		name := strings.TrimLeft(cont.Container.Name, "/")
+		cfgName := s.config.ConfigNameOf(name)
+		if !s.config.IsInstance(cfgName, name) {
+			// No config for this one, nothing to adopt
+			continue
+		}
+		ccfg := s.config[cfgName]
+
+		if !ccfg.ShouldBeRunning(s.hostname) || ccfg.StartTimes != "" {
+			continue
+		}
+
+		diffs, err := s.adoptionDiff(cont, cfgName, ccfg)
+		if err != nil {
+			log.Errorf("Unable to compare container %q for adoption: %s", name, err)
+			continue
+		}
+
+		if len(diffs) > 0 {
+			s.reportAdoptionDiff(id, name, diffs)
+			continue
+		}
+
+		cs, err := ccfg.Checksum()
+		if err != nil {
+			log.Errorf("Unable to calculate checksum for %q: %s", name, err)
+			continue
+		}
+
+		a := adoptedContainer{
+			Name:       name,
+			ConfigName: cfgName,
+			Replica:    replicaIndex(cfgName, name, ccfg),
+			Checksum:   cs,
+			AdoptedAt:  time.Now(),
+		}
+
+		s.lock(lockAdoption, true)
+		s.adopted[id] = a
+		delete(s.adoptionReported, id)
+		err = s.adopted.save(s.adoptedFile)
+		s.unlock(lockAdoption, true)
+		if err != nil {
+			log.Errorf("Unable to save adopted containers: %s", err)
+		}
+
+		log.Infof("Container %q matches its configuration and was adopted", name)
+		s.knownContainers[id] = s.applyAdoption(cont)
+	}
+}
+
+// adoptionDiff lists the differences between the container and its
+// configuration preventing the adoption
+func (s *scheduler) adoptionDiff(cont container, cfgName string, ccfg *config.ContainerConfig) ([]string, error) {
+	name := strings.TrimLeft(cont.Container.Name, "/")
+
+	if len(ccfg.Secrets.Files) > 0 {
+		return []string{"secrets: can not be adopted"}, nil
+	}
+	if len(ccfg.InitContainers) > 0 {
+		return []string{"init_containers: can not be adopted"}, nil
+	}
+
+	want, err := containerOptions(cfgName, name, replicaIndex(cfgName, name, ccfg), ccfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock(lockImages, false)
+	img := s.knownImages[cont.Container.Image].Image
+	s.unlock(lockImages, false)
+
+	return diffContainer(cont.Container, img, want), nil
+}
+
+// reportAdoptionDiff logs the differences once per container and
+// change of the differences
+func (s *scheduler) reportAdoptionDiff(id, name string, diffs []string) {
+	s.lock(lockAdoption, true)
+	defer s.unlock(lockAdoption, true)
+
+	report := strings.Join(diffs, "; ")
+	if s.adoptionReported[id] == report {
+		return
+	}
+	s.adoptionReported[id] = report
+
+	log.WithFields(log.Fields{
+		"container": name,
+	}).Warnf("Container differs from its configuration and was not adopted: %s", report)
+}
+
+func replicaIndex(cfgName, name string, ccfg *config.ContainerConfig) int {
+	for _, i := range ccfg.InstanceIndexes() {
+		if config.InstanceName(cfgName, i) == name {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// diffContainer compares the settings of an existing container to the
+// options it would be created with. Settings not specified in the
+// options are expected to be inherited from the image. Every difference
+// is returned as a human readable line.
+func diffContainer(have *docker.Container, img *docker.Image, want docker.CreateContainerOptions) []string {
+	var (
+		diffs = []string{}
+		hc    = have.HostConfig
+		wc    = want.HostConfig
+		imgC  = &docker.Config{}
+	)
+
+	if img != nil && img.Config != nil {
+		imgC = img.Config
+	}
+	if hc == nil {
+		hc = &docker.HostConfig{}
+	}
+
+	compare := func(field string, haveV, wantV interface{}) {
+		if !reflect.DeepEqual(haveV, wantV) {
+			diffs = append(diffs, fmt.Sprintf("%s: have %v, want %v", field, haveV, wantV))
+		}
+	}
+
+	compare("image", normalizeImageName(have.Config.Image), normalizeImageName(want.Config.Image))
+	compare("environment", envMap(have.Config.Env), envMap(append(append([]string{}, imgC.Env...), want.Config.Env...)))
+
+	wantEntrypoint, wantCmd := want.Config.Entrypoint, want.Config.Cmd
+	if len(wantEntrypoint) == 0 {
+		wantEntrypoint = imgC.Entrypoint
+		if len(wantCmd) == 0 {
+			// Command of the image is only inherited with its entrypoint
+			wantCmd = imgC.Cmd
+		}
+	}
+	compare("entrypoint", nonEmpty(have.Config.Entrypoint), nonEmpty(wantEntrypoint))
+	compare("command", nonEmpty(have.Config.Cmd), nonEmpty(wantCmd))
+
+	compare("labels", userLabels(have.Config.Labels, nil), userLabels(want.Config.Labels, imgC.Labels))
+	compare("user", have.Config.User, defaultString(want.Config.User, imgC.User))
+	compare("working_dir", have.Config.WorkingDir, defaultString(want.Config.WorkingDir, imgC.WorkingDir))
+	compare("stop_signal", defaultString(have.Config.StopSignal, "SIGTERM"), defaultString(want.Config.StopSignal, defaultString(imgC.StopSignal, "SIGTERM")))
+	compare("tty", have.Config.Tty, want.Config.Tty)
+	if want.Config.Hostname != "" {
+		compare("hostname", have.Config.Hostname, want.Config.Hostname)
+	}
+	if want.Config.Domainname != "" {
+		compare("domainname", have.Config.Domainname, want.Config.Domainname)
+	}
+	if wh := want.Config.Healthcheck; wh != nil {
+		// Settings not given are merged from the image by the daemon
+		hh := have.Config.Healthcheck
+		if hh == nil {
+			hh = &docker.HealthConfig{}
+		}
+		if len(wh.Test) > 0 {
+			compare("healthcheck test", hh.Test, wh.Test)
+		}
+		if wh.Interval > 0 {
+			compare("healthcheck interval", hh.Interval, wh.Interval)
+		}
+		if wh.Timeout > 0 {
+			compare("healthcheck timeout", hh.Timeout, wh.Timeout)
+		}
+		if wh.Retries > 0 {
+			compare("healthcheck retries", hh.Retries, wh.Retries)
+		}
+	}
+
+	compare("volumes", sortedStrings(userBinds(hc.Binds)), sortedStrings(wc.Binds))
+	compare("links", sortedStrings(normalizeLinks(hc.Links)), sortedStrings(normalizeLinks(wc.Links)))
+	compare("ports", portBindings(hc.PortBindings), portBindings(wc.PortBindings))
+	compare("cap_add", sortedStrings(hc.CapAdd), sortedStrings(wc.CapAdd))
+	compare("cap_drop", sortedStrings(hc.CapDrop), sortedStrings(wc.CapDrop))
+	compare("privileged", hc.Privileged, wc.Privileged)
+	compare("read_only", hc.ReadonlyRootfs, wc.ReadonlyRootfs)
+	compare("init", hc.Init, wc.Init)
+	compare("group_add", sortedStrings(hc.GroupAdd), sortedStrings(wc.GroupAdd))
+	compare("security_opt", sortedStrings(hc.SecurityOpt), sortedStrings(wc.SecurityOpt))
+	compare("userns_mode", hc.UsernsMode, wc.UsernsMode)
+	compare("ulimits", nonEmptyUlimits(hc.Ulimits), nonEmptyUlimits(wc.Ulimits))
+	compare("sysctls", nonEmptyMap(hc.Sysctls), nonEmptyMap(wc.Sysctls))
+	compare("devices", nonEmptyDevices(hc.Devices), nonEmptyDevices(wc.Devices))
+	compare("pid", hc.PidMode, wc.PidMode)
+	compare("dns", nonEmpty(hc.DNS), nonEmpty(wc.DNS))
+	compare("dns_search", nonEmpty(hc.DNSSearch), nonEmpty(wc.DNSSearch))
+	compare("dns_options", nonEmpty(hc.DNSOptions), nonEmpty(wc.DNSOptions))
+	compare("extra_hosts", sortedStrings(hc.ExtraHosts), sortedStrings(wc.ExtraHosts))
+
+	if wc.IpcMode != "" {
+		compare("ipc", hc.IpcMode, wc.IpcMode)
+	}
+	if wc.ShmSize > 0 {
+		compare("shm_size", hc.ShmSize, wc.ShmSize)
+	}
+	compare("restart_policy", defaultString(hc.RestartPolicy.Name, "no"), defaultString(wc.RestartPolicy.Name, "no"))
+	compare("resources", resourceLimitsOf(hc), resourceLimitsOf(wc))
+
+	haveMode, wantMode := networkMode(hc.NetworkMode), networkMode(wc.NetworkMode)
+	compare("network_mode", haveMode, wantMode)
+	if haveMode == wantMode && have.NetworkSettings != nil && !strings.Contains(wantMode, ":") && wantMode != "host" && wantMode != "none" {
+		networks := []string{}
+		for n := range have.NetworkSettings.Networks {
+			networks = append(networks, n)
+		}
+		compare("networks", sortedStrings(networks), []string{wantMode})
+	}
+
+	if wc.LogConfig.Type != "" {
+		compare("logging", hc.LogConfig.Type, wc.LogConfig.Type)
+		compare("logging options", nonEmptyMap(hc.LogConfig.Config), nonEmptyMap(wc.LogConfig.Config))
+	}
+
+	return diffs
+}
+
+// resourceLimits contains the settings changeable through `docker update`
+type resourceLimits struct {
+	Memory            int64
+	MemoryReservation int64
+	MemorySwap        int64
+	KernelMemory      int64
+	CPUShares         int64
+	CPUQuota          int64
+	CPUPeriod         int64
+	CPUSetCPUs        string
+	CPUSetMEMs        string
+	BlkioWeight       int64
+	PidsLimit         int64
+}
+
+func resourceLimitsOf(hc *docker.HostConfig) resourceLimits {
+	r := resourceLimits{
+		Memory:            hc.Memory,
+		MemoryReservation: hc.MemoryReservation,
+		MemorySwap:        hc.MemorySwap,
+		KernelMemory:      hc.KernelMemory,
+		CPUShares:         hc.CPUShares,
+		CPUQuota:          hc.CPUQuota,
+		CPUPeriod:         hc.CPUPeriod,
+		CPUSetCPUs:        hc.CPUSetCPUs,
+		CPUSetMEMs:        hc.CPUSetMEMs,
+		BlkioWeight:       hc.BlkioWeight,
+		PidsLimit:         hc.PidsLimit,
+	}
+
+	// Unlimited is reported as -1 by some daemon versions
+	if r.MemorySwap < 0 {
+		r.MemorySwap = 0
+	}
+	if r.PidsLimit < 0 {
+		r.PidsLimit = 0
+	}
+
+	return r
+}
+
+func (r resourceLimits) String() string {
+	v := reflect.ValueOf(r)
+	parts := []string{}
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Interface() != reflect.Zero(f.Type()).Interface() {
+			parts = append(parts, fmt.Sprintf("%s=%v", v.Type().Field(i).Name, f.Interface()))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(parts, " ")
+}
+
+// networkMode resolves the default network mode to the bridge network
+func networkMode(in string) string {
+	if in == "" || in == "default" {
+		return "bridge"
+	}
+	return in
+}
+
+// normalizeImageName adds the implicit `latest` tag
+func normalizeImageName(in string) string {
+	if strings.LastIndex(in, ":") > strings.LastIndex(in, "/") || strings.Contains(in, "@") {
+		return in
+	}
+	return in + ":latest"
+}
+
+func envMap(env []string) map[string]string {
+	out := map[string]string{}
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out
+}
+
+// userLabels merges the labels over the defaults leaving out the labels
+// set by the dockermanager itself
+func userLabels(labels, defaults map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, m := range []map[string]string{defaults, labels} {
+		for k, v := range m {
+			if strings.HasPrefix(k, "io.luzifer.dockermanager.") {
+				continue
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// userBinds leaves out the secret mounts created by the dockermanager
+func userBinds(binds []string) []string {
+	out := []string{}
+	for _, b := range binds {
+		if cfg.SecretsDir != "" && strings.HasPrefix(b, path.Clean(cfg.SecretsDir)+"/") {
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// normalizeLinks translates links reported as `/<other>:/<name>/<alias>`
+// and configured links (`<other>[:<alias>]`) into the format
+// `<other>:<alias>`. Links without alias use the name of the other
+// container as alias.
+func normalizeLinks(links []string) []string {
+	out := []string{}
+	for _, l := range links {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			parts = append(parts, parts[0])
+		}
+		out = append(out, strings.TrimPrefix(parts[0], "/")+":"+path.Base(parts[1]))
+	}
+	return out
+}
+
+func portBindings(in map[docker.Port][]docker.PortBinding) []string {
+	out := []string{}
+	for port, bindings := range in {
+		for _, b := range bindings {
+			out = append(out, fmt.Sprintf("%s:%s->%s", b.HostIP, b.HostPort, port))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedStrings(in []string) []string {
+	out := append([]string{}, in...)
+	sort.Strings(out)
+	return out
+}
+
+func defaultString(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func nonEmpty(in []string) []string {
+	if len(in) == 0 {
+		return nil
+	}
+	return in
+}
+
+func nonEmptyMap(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	return in
+}
+
+func nonEmptyUlimits(in []docker.ULimit) []docker.ULimit {
+	if len(in) == 0 {
+		return nil
+	}
+	out := append([]docker.ULimit{}, in...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func nonEmptyDevices(in []docker.Device) []docker.Device {
+	if len(in) == 0 {
+		return nil
+	}
+	return in
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestDiffContainer(t *testing.T) {
+	cfg.SecretsDir = "/run/dockermanager/secrets"
+
+	img := &docker.Image{Config: &docker.Config{
+		Env:        []string{"PATH=/usr/bin", "NGINX_VERSION=1.13"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+		Entrypoint: []string{"/entrypoint.sh"},
+		Labels:     map[string]string{"maintainer": "someone"},
+		StopSignal: "SIGQUIT",
+	}}
+
+	running := func() *docker.Container {
+		return &docker.Container{
+			Config: &docker.Config{
+				Image:      "nginx",
+				Env:        []string{"PATH=/usr/bin", "NGINX_VERSION=1.13", "A=b"},
+				Cmd:        []string{"nginx", "-g", "daemon off;"},
+				Entrypoint: []string{"/entrypoint.sh"},
+				StopSignal: "SIGQUIT",
+				Labels: map[string]string{
+					"maintainer":                       "someone",
+					"io.luzifer.dockermanager.cfghash": "abc",
+				},
+			},
+			HostConfig: &docker.HostConfig{
+				Binds:         []string{"/data:/data", "/run/dockermanager/secrets/web/token:/run/secrets/token:ro"},
+				Links:         []string{"/db:/web/database"},
+				PortBindings:  map[docker.Port][]docker.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}},
+				NetworkMode:   "default",
+				MemorySwap:    -1,
+				RestartPolicy: docker.RestartPolicy{Name: "always"},
+			},
+			NetworkSettings: &docker.NetworkSettings{Networks: map[string]docker.ContainerNetwork{"bridge": {}}},
+		}
+	}
+
+	wanted := func() docker.CreateContainerOptions {
+		return docker.CreateContainerOptions{
+			Config: &docker.Config{
+				Image:  "nginx:latest",
+				Env:    []string{"A=b"},
+				Labels: map[string]string{"io.luzifer.dockermanager.cfghash": "def"},
+			},
+			HostConfig: &docker.HostConfig{
+				Binds:         []string{"/data:/data"},
+				Links:         []string{"db:database"},
+				PortBindings:  map[docker.Port][]docker.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}},
+				RestartPolicy: docker.RestartPolicy{Name: "always"},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(have *docker.Container, want *docker.CreateContainerOptions)
+		diffs  []string
+	}{
+		{
+			name:   "equal",
+			modify: func(*docker.Container, *docker.CreateContainerOptions) {},
+			diffs:  []string{},
+		},
+		{
+			name:   "image tag",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) { w.Config.Image = "nginx:1.13" },
+			diffs:  []string{"image: have nginx:latest, want nginx:1.13"},
+		},
+		{
+			name: "environment",
+			modify: func(h *docker.Container, _ *docker.CreateContainerOptions) {
+				h.Config.Env = append(h.Config.Env, "DEBUG=1")
+			},
+			diffs: []string{"environment: have map[A:b DEBUG:1 NGINX_VERSION:1.13 PATH:/usr/bin], want map[A:b NGINX_VERSION:1.13 PATH:/usr/bin]"},
+		},
+		{
+			name: "command without entrypoint",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) {
+				w.Config.Cmd = []string{"nginx"}
+			},
+			diffs: []string{"command: have [nginx -g daemon off;], want [nginx]"},
+		},
+		{
+			name: "entrypoint drops image command",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) {
+				w.Config.Entrypoint = []string{"/entrypoint.sh"}
+			},
+			diffs: []string{"command: have [nginx -g daemon off;], want []"},
+		},
+		{
+			name: "volumes",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) {
+				w.HostConfig.Binds = append(w.HostConfig.Binds, "/logs:/logs")
+			},
+			diffs: []string{"volumes: have [/data:/data], want [/data:/data /logs:/logs]"},
+		},
+		{
+			name: "ports",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) {
+				w.HostConfig.PortBindings["80/tcp"][0].HostPort = "8081"
+			},
+			diffs: []string{"ports: have [0.0.0.0:8080->80/tcp], want [0.0.0.0:8081->80/tcp]"},
+		},
+		{
+			name: "link without alias",
+			modify: func(h *docker.Container, w *docker.CreateContainerOptions) {
+				h.HostConfig.Links = []string{"/db:/web/db"}
+				w.HostConfig.Links = []string{"db"}
+			},
+			diffs: []string{},
+		},
+		{
+			name: "resources",
+			modify: func(_ *docker.Container, w *docker.CreateContainerOptions) {
+				w.HostConfig.Memory = 512
+			},
+			diffs: []string{"resources: have unlimited, want Memory=512"},
+		},
+		{
+			name: "restart policy",
+			modify: func(h *docker.Container, _ *docker.CreateContainerOptions) {
+				h.HostConfig.RestartPolicy.Name = ""
+			},
+			diffs: []string{"restart_policy: have no, want always"},
+		},
+		{
+			name: "additional network",
+			modify: func(h *docker.Container, _ *docker.CreateContainerOptions) {
+				h.NetworkSettings.Networks["other"] = docker.ContainerNetwork{}
+			},
+			diffs: []string{"networks: have [bridge other], want [bridge]"},
+		},
+		{
+			name: "healthcheck only checks given settings",
+			modify: func(h *docker.Container, w *docker.CreateContainerOptions) {
+				h.Config.Healthcheck = &docker.HealthConfig{Test: []string{"CMD", "true"}, Retries: 3}
+				w.Config.Healthcheck = &docker.HealthConfig{Retries: 5}
+			},
+			diffs: []string{"healthcheck retries: have 3, want 5"},
+		},
+		{
+			name: "logging without driver is not checked",
+			modify: func(h *docker.Container, _ *docker.CreateContainerOptions) {
+				h.HostConfig.LogConfig = docker.LogConfig{Type: "syslog"}
+			},
+			diffs: []string{},
+		},
+	} {
+		have, want := running(), wanted()
+		tc.modify(have, &want)
+
+		if diffs := diffContainer(have, img, want); !reflect.DeepEqual(diffs, tc.diffs) {
+			t.Errorf("%s: got %q, expected %q", tc.name, diffs, tc.diffs)
+		}
+	}
+}
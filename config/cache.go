@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// CachedConfig represents the last known good configuration stored
+// on disk to be used when the config source is not available
+type CachedConfig struct {
+	Source    string    `json:"source"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Body      string    `json:"body"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// WriteCache stores the raw configuration together with its source
+// metadata and signature into the given file
+func WriteCache(filename, source string, body, signature []byte) error {
+	data, err := json.Marshal(CachedConfig{
+		Source:    source,
+		FetchedAt: time.Now(),
+		Body:      string(body),
+		Signature: string(signature),
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to marshal config cache: %s", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Unable to create cache dir: %s", err)
+	}
+
+	// Write to a temporary file first to never leave a broken cache behind
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write config cache: %s", err)
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// ReadCache reads the cached configuration from the given file
+func ReadCache(filename string) (*CachedConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config cache: %s", err)
+	}
+
+	c := &CachedConfig{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Unable to parse config cache: %s", err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cnf/structhash"
+)
+
+// checksumVersion is the structhash version of the settings added after
+// the first release. They are only hashed when set to keep the checksum
+// (and therefore the containers) of existing configurations stable.
+const checksumVersion = 2
+
+// Checksum generates a hash over the ContainerConfig to compare it to older versions
+func (c ContainerConfig) Checksum() (string, error) {
+	data := structhash.Dump(c, 1)
+
+	extras := c.checksumExtras()
+
+	digests, err := c.Secrets.contentDigests()
+	if err != nil {
+		return "", err
+	}
+	if len(digests) > 0 {
+		extras["SecretDigests"] = digests
+	}
+
+	if digests, err = c.seccompProfileDigests(); err != nil {
+		return "", err
+	}
+	if len(digests) > 0 {
+		extras["SeccompProfileDigests"] = digests
+	}
+
+	// Containers inherit the --container-log-driver, changing it needs
+	// to recreate them
+	if l := c.EffectiveLogging(); l.Driver != "" || len(l.Options) > 0 {
+		extras["Logging"] = l
+	}
+
+	if len(extras) > 0 {
+		data = append(data, structhash.Dump(extras, checksumVersion)...)
+	}
+
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
+// checksumExtras collects the non-empty settings tagged with the
+// checksumVersion
+func (c ContainerConfig) checksumExtras() map[string]interface{} {
+	var (
+		extras = map[string]interface{}{}
+		v      = reflect.ValueOf(c)
+		tag    = fmt.Sprintf("version:%d", checksumVersion)
+	)
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !strings.Contains(f.Tag.Get("hash"), tag) || isEmptyValue(v.Field(i)) {
+			continue
+		}
+		extras[f.Name] = v.Field(i).Interface()
+	}
+
+	return extras
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isEmptyValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
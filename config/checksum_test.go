@@ -0,0 +1,174 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Checksums calculated by the first release for the same configuration.
+// If they change every managed container is recreated on update.
+func TestChecksumBaseline(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, checksum string
+	}{
+		{
+			name:     "minimal",
+			checksum: "7792ce7f0970ced78d2d76087997b08dd58fbdd0",
+			config: `
+web:
+  hosts: [ALL]
+  image: nginx
+  tag: latest
+`,
+		},
+		{
+			name:     "all baseline settings",
+			checksum: "d719a468846c8ca22d22f1c051a3529636de7705",
+			config: `
+web:
+  command: ["nginx", "-g", "daemon off;"]
+  environment: ["A=b", "C=d"]
+  hosts: [host1, host2]
+  image: registry.example.com/nginx
+  tag: "1.2"
+  links: ["db:database"]
+  ports:
+    - container: 80/tcp
+      local: 0.0.0.0:8080
+  update_times: ["02:00-04:00"]
+  volumes: ["/data:/data"]
+  stop_timeout: 20
+  labels: {a: b}
+  cap_add: [NET_ADMIN]
+  depends_on: [db]
+db:
+  hosts: [ALL]
+  image: postgres
+  tag: latest
+`,
+		},
+		{
+			name:     "scheduled",
+			checksum: "bb9c9d0e474f3d4ba0b385e85c4b79310733084e",
+			config: `
+web:
+  hosts: [ALL]
+  image: busybox
+  tag: latest
+  start_times: "0 * * * *"
+`,
+		},
+	} {
+		cfg, err := ParseConfig([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("%s: Unable to parse config: %s", tc.name, err)
+		}
+
+		cs, err := cfg["web"].Checksum()
+		if err != nil {
+			t.Fatalf("%s: Unable to calculate checksum: %s", tc.name, err)
+		}
+
+		if cs != tc.checksum {
+			t.Errorf("%s: Checksum changed: got %s, expected %s", tc.name, cs, tc.checksum)
+		}
+	}
+}
+
+func TestChecksumNewSettings(t *testing.T) {
+	base := ContainerConfig{Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest"}
+	baseSum, _ := base.Checksum()
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*ContainerConfig)
+	}{
+		{"cap_drop", func(c *ContainerConfig) { c.DropCapabilities = []string{"ALL"} }},
+		{"privileged", func(c *ContainerConfig) { c.Privileged = true }},
+		{"secrets", func(c *ContainerConfig) {
+			c.Secrets = SecretsConfig{Files: []SecretConfig{{Name: "a", Value: "b"}}}
+		}},
+		{"healthcheck", func(c *ContainerConfig) { c.Healthcheck.Retries = 3 }},
+		{"dns", func(c *ContainerConfig) { c.DNS = []string{"1.1.1.1"} }},
+		{"init", func(c *ContainerConfig) { c.Init = true }},
+	} {
+		c := base
+		tc.modify(&c)
+
+		cs, err := c.Checksum()
+		if err != nil {
+			t.Fatalf("%s: Unable to calculate checksum: %s", tc.name, err)
+		}
+
+		if cs == baseSum {
+			t.Errorf("%s: Checksum did not change", tc.name)
+		}
+	}
+
+	// Explicitly empty settings must not change the checksum
+	empty := base
+	empty.DropCapabilities = []string{}
+	empty.Sysctls = map[string]string{}
+	if cs, _ := empty.Checksum(); cs != baseSum {
+		t.Errorf("Empty settings changed the checksum")
+	}
+}
+
+func TestChecksumSecretFileContent(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := ContainerConfig{
+		Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest",
+		Secrets: SecretsConfig{Files: []SecretConfig{{Name: "token", File: f.Name()}}},
+	}
+
+	checksum := func(content string) string {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			t.Fatalf("Unable to write secret: %s", err)
+		}
+		cs, err := c.Checksum()
+		if err != nil {
+			t.Fatalf("Unable to calculate checksum: %s", err)
+		}
+		return cs
+	}
+
+	if checksum("old") == checksum("new") {
+		t.Errorf("Rotated secret file did not change the checksum")
+	}
+
+	os.Remove(f.Name())
+	if _, err := c.Checksum(); err == nil {
+		t.Errorf("Missing secret file did not cause an error")
+	}
+}
+
+func TestChecksumDefaultLogging(t *testing.T) {
+	defer SetDefaultLogging("", nil)
+
+	var (
+		inherit  = ContainerConfig{Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest"}
+		explicit = inherit
+	)
+	explicit.Logging = LoggingConfig{Driver: "json-file"}
+
+	baseSum, _ := inherit.Checksum()
+	explicitSum, _ := explicit.Checksum()
+
+	if err := SetDefaultLogging("syslog", []string{"tag=web"}); err != nil {
+		t.Fatalf("Unable to set default logging: %s", err)
+	}
+
+	if cs, _ := inherit.Checksum(); cs == baseSum {
+		t.Errorf("Changed default logging did not change the checksum")
+	}
+	if cs, _ := explicit.Checksum(); cs != explicitSum {
+		t.Errorf("Default logging changed the checksum of a container having its own driver")
+	}
+}
@@ -0,0 +1,603 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// composeFile represents the subset of a docker-compose.yml (v2 / v3)
+// which can be translated into a Config
+type composeFile struct {
+	Version  string                    `yaml:"version"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image           string                 `yaml:"image"`
+	ContainerName   string                 `yaml:"container_name"`
+	Command         composeCommand         `yaml:"command"`
+	Entrypoint      composeCommand         `yaml:"entrypoint"`
+	Environment     composeMapOrList       `yaml:"environment"`
+	EnvFile         composeStringOrList    `yaml:"env_file"`
+	Ports           []composePort          `yaml:"ports"`
+	Volumes         []composeVolume        `yaml:"volumes"`
+	Links           []string               `yaml:"links"`
+	DependsOn       composeMapOrList       `yaml:"depends_on"`
+	Labels          composeMapOrList       `yaml:"labels"`
+	CapAdd          []string               `yaml:"cap_add"`
+	CapDrop         []string               `yaml:"cap_drop"`
+	Restart         string                 `yaml:"restart"`
+	Healthcheck     *composeHealthcheck    `yaml:"healthcheck"`
+	WorkingDir      string                 `yaml:"working_dir"`
+	User            string                 `yaml:"user"`
+	Hostname        string                 `yaml:"hostname"`
+	Privileged      bool                   `yaml:"privileged"`
+	ReadOnly        bool                   `yaml:"read_only"`
+	ExtraHosts      composeMapOrList       `yaml:"extra_hosts"`
+	DNS             composeStringOrList    `yaml:"dns"`
+	StopSignal      string                 `yaml:"stop_signal"`
+	StopGracePeriod string                 `yaml:"stop_grace_period"`
+	Logging         *composeLogging        `yaml:"logging"`
+	Unsupported     map[string]interface{} `yaml:",inline"`
+}
+
+type composeHealthcheck struct {
+	Test        composeStringOrList `yaml:"test"`
+	Interval    string              `yaml:"interval"`
+	Timeout     string              `yaml:"timeout"`
+	Retries     int                 `yaml:"retries"`
+	StartPeriod string              `yaml:"start_period"`
+	Disable     bool                `yaml:"disable"`
+}
+
+type composeLogging struct {
+	Driver  string            `yaml:"driver"`
+	Options map[string]string `yaml:"options"`
+}
+
+// composeStringOrList accepts a single string or a list of strings
+type composeStringOrList []string
+
+func (c *composeStringOrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*c = composeStringOrList{single}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// composeCommand accepts a command as a list or as a string which is
+// split like a shell would do
+type composeCommand []string
+
+func (c *composeCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		words, err := splitShellWords(single)
+		if err != nil {
+			return err
+		}
+		*c = words
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// composeMapOrList accepts a list of strings or a map. Map entries
+// without value are stored with a nil value.
+type composeMapOrList struct {
+	List []string
+	Map  map[string]*string
+}
+
+func (c *composeMapOrList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.List); err == nil {
+		return nil
+	}
+
+	raw := map[string]interface{}{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	c.Map = map[string]*string{}
+	for k, v := range raw {
+		switch v := v.(type) {
+		case nil:
+			c.Map[k] = nil
+		case map[interface{}]interface{}:
+			// Long syntax of depends_on containing conditions
+			c.Map[k] = nil
+		default:
+			s := fmt.Sprintf("%v", v)
+			c.Map[k] = &s
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of a map or the entries of a list
+func (c composeMapOrList) Keys() []string {
+	if c.Map == nil {
+		return c.List
+	}
+
+	keys := []string{}
+	for k := range c.Map {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Entries joins the keys and values of a map using the separator or
+// returns the entries of a list. Map keys without value are returned
+// as second result.
+func (c composeMapOrList) Entries(sep string) ([]string, []string) {
+	if c.Map == nil {
+		return c.List, nil
+	}
+
+	entries, missing := []string{}, []string{}
+	for _, k := range c.Keys() {
+		if c.Map[k] == nil {
+			missing = append(missing, k)
+			continue
+		}
+		entries = append(entries, k+sep+*c.Map[k])
+	}
+	return entries, missing
+}
+
+// composePort accepts the short port syntax (shared with PortConfig)
+// and the long syntax using target, published and protocol
+type composePort struct {
+	PortConfig
+}
+
+func (c *composePort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err == nil {
+		return c.parseShort(short)
+	}
+
+	// Target and published may be numbers or strings containing a port range
+	var long struct {
+		Target    string `yaml:"target"`
+		Published string `yaml:"published"`
+		HostIP    string `yaml:"host_ip"`
+		Protocol  string `yaml:"protocol"`
+	}
+	if err := unmarshal(&long); err != nil {
+		return err
+	}
+
+	c.Container = long.Target
+	if long.Protocol != "" {
+		c.Container += "/" + long.Protocol
+	}
+
+	hostIP := long.HostIP
+	if strings.Contains(hostIP, ":") {
+		hostIP = "[" + hostIP + "]"
+	}
+
+	switch {
+	case hostIP != "" && long.Published != "":
+		c.Local = hostIP + ":" + long.Published
+	case hostIP != "":
+		c.Local = hostIP
+	default:
+		c.Local = long.Published
+	}
+	return nil
+}
+
+// composeVolume accepts the short and the long volume syntax
+type composeVolume struct {
+	Type     string `yaml:"type"`
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only"`
+
+	short string
+}
+
+func (c *composeVolume) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.short); err == nil {
+		return nil
+	}
+
+	type plain composeVolume
+	return unmarshal((*plain)(c))
+}
+
+// ConvertCompose translates a docker-compose.yml into the YAML format
+// of the dockermanager configuration. Relative paths (volumes, env_file)
+// are resolved against baseDir, if it is empty they are not supported.
+// Settings which could not be translated are returned as warnings.
+func ConvertCompose(body []byte, baseDir string) ([]byte, []string, error) {
+	var (
+		top      = map[string]interface{}{}
+		compose  = composeFile{}
+		result   = Config{}
+		warnings = []string{}
+	)
+
+	if err := yaml.Unmarshal(body, &top); err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse compose file: %s", err)
+	}
+
+	for _, k := range sortedKeys(top) {
+		if k != "version" && k != "services" {
+			warnings = append(warnings, fmt.Sprintf("Top-level key %q is not supported", k))
+		}
+	}
+
+	if err := yaml.Unmarshal(body, &compose); err != nil {
+		return nil, nil, fmt.Errorf("Unable to parse compose file: %s", err)
+	}
+
+	if len(compose.Services) == 0 {
+		return nil, nil, errors.New("Compose file does not contain services")
+	}
+
+	if bytes.Contains(body, []byte("${")) {
+		warnings = append(warnings, "Variable interpolation is not supported, values are used literally")
+	}
+
+	// Services are addressed by their container_name if set
+	names := map[string]string{}
+	for svcName, svc := range compose.Services {
+		names[svcName] = svcName
+		if svc.ContainerName != "" {
+			names[svcName] = svc.ContainerName
+		}
+	}
+	resolve := func(n string) string {
+		if name, ok := names[n]; ok {
+			return name
+		}
+		return n
+	}
+
+	svcNames := []string{}
+	for svcName := range compose.Services {
+		svcNames = append(svcNames, svcName)
+	}
+	sort.Strings(svcNames)
+
+	for _, svcName := range svcNames {
+		svc := compose.Services[svcName]
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf("Service %q: ", svcName)+fmt.Sprintf(format, args...))
+		}
+
+		ccfg, err := svc.convert(baseDir, resolve, warn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to convert service %q: %s", svcName, err)
+		}
+
+		result[names[svcName]] = ccfg
+	}
+
+	out, err := yaml.Marshal(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to marshal converted config: %s", err)
+	}
+
+	return out, warnings, nil
+}
+
+func (s composeService) convert(baseDir string, resolve func(string) string, warn func(string, ...interface{})) (*ContainerConfig, error) {
+	if s.Image == "" {
+		return nil, errors.New("Services without image (build) are not supported")
+	}
+
+	image, tag, err := SplitImageTag(s.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ContainerConfig{
+		Hosts:            []string{"ALL"},
+		Image:            image,
+		Tag:              tag,
+		Command:          s.Command,
+		Entrypoint:       s.Entrypoint,
+		AddCapabilities:  s.CapAdd,
+		DropCapabilities: s.CapDrop,
+		WorkingDir:       s.WorkingDir,
+		User:             s.User,
+		Hostname:         s.Hostname,
+		Privileged:       s.Privileged,
+		ReadOnly:         s.ReadOnly,
+		DNS:              s.DNS,
+		StopSignal:       s.StopSignal,
+	}
+
+	for _, k := range sortedKeys(s.Unsupported) {
+		warn("Key %q is not supported", k)
+	}
+
+	switch s.Restart {
+	case "", "always", "unless-stopped":
+	default:
+		warn("Restart policy %q is not supported, containers are always restarted", s.Restart)
+	}
+
+	// Environment from env_file is overridden by environment
+	for _, fn := range s.EnvFile {
+		if baseDir == "" && !path.IsAbs(fn) {
+			warn("Relative env_file %q is not supported for this config source", fn)
+			continue
+		}
+		env, err := readEnvFile(resolvePath(baseDir, fn))
+		if err != nil {
+			return nil, err
+		}
+		c.Environment = append(c.Environment, env...)
+	}
+
+	env, missing := s.Environment.Entries("=")
+	c.Environment = append(c.Environment, env...)
+	for _, k := range missing {
+		warn("Environment variable %q without value is not supported", k)
+	}
+
+	for _, p := range s.Ports {
+		c.Ports = append(c.Ports, p.PortConfig)
+	}
+
+	for _, l := range s.Links {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) == 1 {
+			parts = append(parts, parts[0])
+		}
+		c.Links = append(c.Links, resolve(parts[0])+":"+parts[1])
+	}
+
+	for _, d := range s.DependsOn.Keys() {
+		c.DependsOn = append(c.DependsOn, resolve(d))
+	}
+
+	labels, missing := s.Labels.Entries("=")
+	for _, k := range missing {
+		labels = append(labels, k+"=")
+	}
+	if len(labels) > 0 {
+		c.Labels = map[string]string{}
+		for _, l := range labels {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) == 1 {
+				parts = append(parts, "")
+			}
+			c.Labels[parts[0]] = parts[1]
+		}
+	}
+
+	hosts, missing := s.ExtraHosts.Entries(":")
+	c.ExtraHosts = hosts
+	for _, k := range missing {
+		warn("Extra host %q without IP is not supported", k)
+	}
+
+	for _, v := range s.Volumes {
+		vol, err := v.convert(baseDir)
+		if err != nil {
+			warn("%s", err)
+			continue
+		}
+		c.Volumes = append(c.Volumes, vol)
+	}
+
+	if s.StopGracePeriod != "" {
+		d, err := time.ParseDuration(s.StopGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stop_grace_period %q", s.StopGracePeriod)
+		}
+		c.StopTimeout = uint(d.Seconds())
+	}
+
+	if s.Healthcheck != nil {
+		c.Healthcheck = s.Healthcheck.convert(warn)
+	}
+
+	if s.Logging != nil {
+		c.Logging = LoggingConfig{Driver: s.Logging.Driver, Options: s.Logging.Options}
+	}
+
+	return c, nil
+}
+
+func (h composeHealthcheck) convert(warn func(string, ...interface{})) HealthcheckConfig {
+	if h.Disable {
+		return HealthcheckConfig{Test: []string{"NONE"}}
+	}
+
+	if h.StartPeriod != "" {
+		warn("Healthcheck start_period is not supported")
+	}
+
+	test := []string(h.Test)
+	if len(test) == 1 && test[0] != "NONE" {
+		// Single string is executed by the shell
+		test = []string{"CMD-SHELL", test[0]}
+	}
+
+	return HealthcheckConfig{
+		Test:     test,
+		Interval: h.Interval,
+		Timeout:  h.Timeout,
+		Retries:  h.Retries,
+	}
+}
+
+func (v composeVolume) convert(baseDir string) (string, error) {
+	if v.short == "" {
+		switch v.Type {
+		case "bind", "volume":
+		default:
+			return "", fmt.Errorf("Volume type %q is not supported", v.Type)
+		}
+
+		v.short = v.Source + ":" + v.Target
+		if v.ReadOnly {
+			v.short += ":ro"
+		}
+	}
+
+	parts := strings.Split(v.short, ":")
+	if len(parts) < 2 || parts[0] == "" {
+		return "", fmt.Errorf("Anonymous volume %q is not supported", v.short)
+	}
+
+	if strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[0], "~") {
+		if baseDir == "" || strings.HasPrefix(parts[0], "~") {
+			return "", fmt.Errorf("Relative volume %q is not supported for this config source", v.short)
+		}
+		parts[0] = resolvePath(baseDir, parts[0])
+	}
+
+	return strings.Join(parts, ":"), nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resolvePath(baseDir, p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(baseDir, p)
+}
+
+func readEnvFile(filename string) ([]string, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read env_file: %s", err)
+	}
+
+	env := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env = append(env, line)
+	}
+
+	return env, scanner.Err()
+}
+
+// SplitImageTag splits `registry:5000/image:tag` into image and tag
+// (default latest)
+func SplitImageTag(in string) (string, string, error) {
+	if strings.Contains(in, "@") {
+		return "", "", fmt.Errorf("Image digest references (%q) are not supported", in)
+	}
+
+	if i := strings.LastIndex(in, ":"); i > strings.LastIndex(in, "/") {
+		return in[:i], in[i+1:], nil
+	}
+
+	return in, "latest", nil
+}
+
+// splitShellWords splits a command string into its words respecting
+// single and double quotes and backslash escapes
+func splitShellWords(in string) ([]string, error) {
+	var (
+		words   = []string{}
+		current = new(bytes.Buffer)
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range in {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Command %q has unterminated quotes", in)
+	}
+
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
+
+// IsComposeLocation checks whether the config location looks like a
+// docker-compose file
+func IsComposeLocation(location string) bool {
+	base := path.Base(location)
+	if i := strings.LastIndex(base, ":"); i >= 0 {
+		// git sources specify the path after the branch
+		base = path.Base(base[i+1:])
+	}
+
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	return (ext == ".yml" || ext == ".yaml") &&
+		(name == "compose" || strings.HasPrefix(name, "docker-compose"))
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		words []string
+		err   bool
+	}{
+		{in: "", words: []string{}},
+		{in: "nginx -g 'daemon off;'", words: []string{"nginx", "-g", "daemon off;"}},
+		{in: `sh -c "echo \"hi there\""`, words: []string{"sh", "-c", `echo "hi there"`}},
+		{in: `echo 'a\b'`, words: []string{"echo", `a\b`}},
+		{in: `echo a\ b`, words: []string{"echo", "a b"}},
+		{in: "  a \t b\n", words: []string{"a", "b"}},
+		{in: `echo ""`, words: []string{"echo", ""}},
+		{in: `a"b"c`, words: []string{"abc"}},
+		{in: `echo "open`, err: true},
+		{in: `echo \`, err: true},
+	} {
+		words, err := splitShellWords(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if !tc.err && !reflect.DeepEqual(words, tc.words) {
+			t.Errorf("%q: got %q, expected %q", tc.in, words, tc.words)
+		}
+	}
+}
+
+func TestSplitImageTag(t *testing.T) {
+	for _, tc := range []struct {
+		in, image, tag string
+		err            bool
+	}{
+		{in: "nginx", image: "nginx", tag: "latest"},
+		{in: "nginx:1.13", image: "nginx", tag: "1.13"},
+		{in: "registry:5000/app", image: "registry:5000/app", tag: "latest"},
+		{in: "registry:5000/app:v1", image: "registry:5000/app", tag: "v1"},
+		{in: "nginx@sha256:abc", err: true},
+	} {
+		image, tag, err := SplitImageTag(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if !tc.err && (image != tc.image || tag != tc.tag) {
+			t.Errorf("%q: got %q / %q, expected %q / %q", tc.in, image, tag, tc.image, tc.tag)
+		}
+	}
+}
+
+func TestIsComposeLocation(t *testing.T) {
+	for location, expected := range map[string]bool{
+		"docker-compose.yml":                                  true,
+		"/srv/docker-compose.prod.yaml":                       true,
+		"compose.yml":                                         true,
+		"https://example.com/compose.yaml":                    true,
+		"git+https://example.com/repo.git#master:compose.yml": true,
+		"config.yml":                                          false,
+		"docker-compose.json":                                 false,
+	} {
+		if IsComposeLocation(location) != expected {
+			t.Errorf("%q: expected %v", location, expected)
+		}
+	}
+}
+
+func TestConvertCompose(t *testing.T) {
+	compose := `
+version: "3"
+services:
+  web:
+    image: nginx:1.13
+    container_name: frontend
+    command: nginx -g 'daemon off;'
+    environment:
+      A: b
+      EMPTY:
+    ports:
+      - "8080:80"
+      - target: 443
+        published: 8443
+        protocol: tcp
+    volumes:
+      - ./html:/usr/share/nginx/html:ro
+      - type: bind
+        source: /etc/ssl
+        target: /etc/ssl
+        read_only: true
+      - cache:/var/cache
+    links: [db]
+    depends_on: [db]
+    labels: [a=b]
+    restart: on-failure
+    stop_grace_period: 30s
+    healthcheck:
+      test: curl -f http://localhost/
+      retries: 3
+    deploy:
+      replicas: 2
+  db:
+    image: postgres
+networks:
+  default: {}
+`
+
+	body, warnings, err := ConvertCompose([]byte(compose), "/srv/app")
+	if err != nil {
+		t.Fatalf("Unable to convert: %s", err)
+	}
+
+	cfg, err := ParseConfig(body)
+	if err != nil {
+		t.Fatalf("Unable to parse converted config: %s\n%s", err, body)
+	}
+
+	web, ok := cfg["frontend"]
+	if !ok {
+		t.Fatalf("Service was not named by its container_name:\n%s", body)
+	}
+
+	for name, pair := range map[string][2]interface{}{
+		"image":        {web.Image, "nginx"},
+		"tag":          {web.Tag, "1.13"},
+		"command":      {[]string(web.Command), []string{"nginx", "-g", "daemon off;"}},
+		"environment":  {web.Environment, []string{"A=b"}},
+		"ports":        {web.Ports, []PortConfig{{Container: "80", Local: "8080"}, {Container: "443/tcp", Local: "8443"}}},
+		"volumes":      {web.Volumes, []string{"/srv/app/html:/usr/share/nginx/html:ro", "/etc/ssl:/etc/ssl:ro", "cache:/var/cache"}},
+		"links":        {web.Links, []string{"db:db"}},
+		"depends_on":   {web.DependsOn, []string{"db"}},
+		"labels":       {web.Labels, map[string]string{"a": "b"}},
+		"stop_timeout": {web.StopTimeout, uint(30)},
+		"healthcheck":  {web.Healthcheck.Test, []string{"CMD-SHELL", "curl -f http://localhost/"}},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s: got %#v, expected %#v", name, pair[0], pair[1])
+		}
+	}
+
+	expectedWarnings := []string{
+		`Top-level key "networks" is not supported`,
+		`Service "web": Key "deploy" is not supported`,
+		`Service "web": Restart policy "on-failure" is not supported`,
+		`Service "web": Environment variable "EMPTY" without value is not supported`,
+	}
+	for _, w := range expectedWarnings {
+		found := false
+		for _, got := range warnings {
+			found = found || strings.HasPrefix(got, w)
+		}
+		if !found {
+			t.Errorf("Warning %q is missing in %q", w, warnings)
+		}
+	}
+	if len(warnings) != len(expectedWarnings) {
+		t.Errorf("Expected %d warnings, got %q", len(expectedWarnings), warnings)
+	}
+}
+
+func TestConvertComposeLongPorts(t *testing.T) {
+	compose := `
+services:
+  web:
+    image: nginx
+    ports:
+      - target: 80
+        published: 8080
+        host_ip: 127.0.0.1
+      - target: 53
+        published: "5353"
+        host_ip: "::1"
+        protocol: udp
+      - target: "9000-9002"
+        published: "9000-9002"
+      - target: 81
+        host_ip: 127.0.0.1
+`
+
+	body, _, err := ConvertCompose([]byte(compose), "")
+	if err != nil {
+		t.Fatalf("Unable to convert compose file: %s", err)
+	}
+
+	cfg, err := ParseConfig(body)
+	if err != nil {
+		t.Fatalf("Unable to parse converted config: %s\n%s", err, body)
+	}
+
+	expected := []PortConfig{
+		{Container: "80", Local: "127.0.0.1:8080"},
+		{Container: "53/udp", Local: "[::1]:5353"},
+		{Container: "9000-9002", Local: "9000-9002"},
+		{Container: "81", Local: "127.0.0.1"},
+	}
+	if !reflect.DeepEqual(cfg["web"].Ports, expected) {
+		t.Errorf("Unexpected ports %v, expected %v", cfg["web"].Ports, expected)
+	}
+}
+
+func TestConvertComposeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, compose string
+	}{
+		{"no services", `version: "3"`},
+		{"build only", "services:\n  app:\n    build: .\n"},
+		{"digest", "services:\n  app:\n    image: nginx@sha256:abc\n"},
+		{"invalid grace period", "services:\n  app:\n    image: nginx\n    stop_grace_period: soon\n"},
+		{"invalid yaml", "services: ["},
+	} {
+		if _, _, err := ConvertCompose([]byte(tc.compose), ""); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -3,14 +3,10 @@ package config
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
 	"time"
 
 	"github.com/Luzifer/go_helpers/str"
-	"github.com/cnf/structhash"
-	"github.com/robfig/cron"
 	"gopkg.in/yaml.v2"
 )
 
@@ -19,22 +15,67 @@ type Config map[string]*ContainerConfig
 
 // ContainerConfig represents a single container to be started on the specified Hosts
 type ContainerConfig struct {
-	Command         []string          `yaml:"command,omitempty" json:"command"`
-	Environment     []string          `yaml:"environment,omitempty" json:"environment"`
-	Hosts           []string          `yaml:"hosts" json:"hosts"`
-	Image           string            `yaml:"image" json:"image"`
-	Links           []string          `yaml:"links" json:"links"`
-	Ports           []PortConfig      `yaml:"ports,omitempty" json:"ports"`
-	Tag             string            `yaml:"tag" json:"tag"`
-	UpdateTimes     []string          `yaml:"update_times,omitempty" json:"updatetimes"`
-	Volumes         []string          `yaml:"volumes,omitempty" json:"volumes"`
-	StartTimes      string            `yaml:"start_times" json:"starttimes"`
-	StopTimeout     uint              `yaml:"stop_timeout" json:"stoptimes"`
-	Labels          map[string]string `yaml:"labels" json:"labels"`
-	AddCapabilities []string          `yaml:"cap_add" json:"cap_add"`
-	DependsOn       []string          `yaml:"depends_on" json:"depends_on"`
-
-	nextRun *time.Time `hash:"-"`
+	Command          []string                `yaml:"command,omitempty" json:"command"`
+	Environment      []string                `yaml:"environment,omitempty" json:"environment"`
+	Hosts            []string                `yaml:"hosts" json:"hosts"`
+	Image            string                  `yaml:"image" json:"image"`
+	Links            []string                `yaml:"links" json:"links"`
+	Ports            []PortConfig            `yaml:"ports,omitempty" json:"ports"`
+	Tag              string                  `yaml:"tag" json:"tag"`
+	UpdateTimes      []string                `yaml:"update_times,omitempty" json:"updatetimes"`
+	Volumes          []string                `yaml:"volumes,omitempty" json:"volumes"`
+	StartTimes       string                  `yaml:"start_times" json:"starttimes"`
+	StopTimeout      uint                    `yaml:"stop_timeout" json:"stoptimes"`
+	Labels           map[string]string       `yaml:"labels" json:"labels"`
+	AddCapabilities  []string                `yaml:"cap_add" json:"cap_add"`
+	DependsOn        []string                `yaml:"depends_on" json:"depends_on"`
+	Secrets          SecretsConfig           `yaml:"secrets,omitempty" json:"secrets" hash:"version:2"`
+	CatchUp          string                  `yaml:"catch_up,omitempty" json:"catch_up" hash:"version:2"`
+	Concurrency      string                  `yaml:"concurrency_policy,omitempty" json:"concurrency_policy" hash:"version:2"`
+	MaxRuntime       string                  `yaml:"max_runtime,omitempty" json:"max_runtime" hash:"version:2"`
+	Retry            RetryConfig             `yaml:"retry,omitempty" json:"retry" hash:"version:2"`
+	Timezone         string                  `yaml:"timezone,omitempty" json:"timezone" hash:"version:2"`
+	Jitter           string                  `yaml:"jitter,omitempty" json:"jitter" hash:"version:2"`
+	Critical         bool                    `yaml:"critical,omitempty" json:"critical" hash:"version:2"`
+	UpdatePolicy     string                  `yaml:"update_policy,omitempty" json:"update_policy" hash:"version:2"`
+	UpdateStrategy   string                  `yaml:"update_strategy,omitempty" json:"update_strategy" hash:"version:2"`
+	Hooks            HooksConfig             `yaml:"hooks,omitempty" json:"hooks" hash:"version:2"`
+	InitContainers   []InitContainerConfig   `yaml:"init_containers,omitempty" json:"init_containers" hash:"version:2"`
+	Replicas         int                     `yaml:"replicas,omitempty" json:"replicas" hash:"-"`
+	DropCapabilities []string                `yaml:"cap_drop,omitempty" json:"cap_drop" hash:"version:2"`
+	ReadOnly         bool                    `yaml:"read_only,omitempty" json:"read_only" hash:"version:2"`
+	User             string                  `yaml:"user,omitempty" json:"user" hash:"version:2"`
+	GroupAdd         []string                `yaml:"group_add,omitempty" json:"group_add" hash:"version:2"`
+	SecurityOpt      []string                `yaml:"security_opt,omitempty" json:"security_opt" hash:"version:2"`
+	UsernsMode       string                  `yaml:"userns_mode,omitempty" json:"userns_mode" hash:"version:2"`
+	Privileged       bool                    `yaml:"privileged,omitempty" json:"privileged" hash:"version:2"`
+	Entrypoint       []string                `yaml:"entrypoint,omitempty" json:"entrypoint" hash:"version:2"`
+	WorkingDir       string                  `yaml:"working_dir,omitempty" json:"working_dir" hash:"version:2"`
+	Hostname         string                  `yaml:"hostname,omitempty" json:"hostname" hash:"version:2"`
+	Domainname       string                  `yaml:"domainname,omitempty" json:"domainname" hash:"version:2"`
+	StopSignal       string                  `yaml:"stop_signal,omitempty" json:"stop_signal" hash:"version:2"`
+	Tty              bool                    `yaml:"tty,omitempty" json:"tty" hash:"version:2"`
+	Init             bool                    `yaml:"init,omitempty" json:"init" hash:"version:2"`
+	ShmSize          string                  `yaml:"shm_size,omitempty" json:"shm_size" hash:"version:2"`
+	Ulimits          map[string]UlimitConfig `yaml:"ulimits,omitempty" json:"ulimits" hash:"version:2"`
+	Sysctls          map[string]string       `yaml:"sysctls,omitempty" json:"sysctls" hash:"version:2"`
+	Devices          []string                `yaml:"devices,omitempty" json:"devices" hash:"version:2"`
+	PidMode          string                  `yaml:"pid,omitempty" json:"pid" hash:"version:2"`
+	IpcMode          string                  `yaml:"ipc,omitempty" json:"ipc" hash:"version:2"`
+	DNS              []string                `yaml:"dns,omitempty" json:"dns" hash:"version:2"`
+	DNSSearch        []string                `yaml:"dns_search,omitempty" json:"dns_search" hash:"version:2"`
+	DNSOptions       []string                `yaml:"dns_options,omitempty" json:"dns_options" hash:"version:2"`
+	ExtraHosts       []string                `yaml:"extra_hosts,omitempty" json:"extra_hosts" hash:"version:2"`
+	Logging          LoggingConfig           `yaml:"logging,omitempty" json:"logging" hash:"-"`
+	Healthcheck      HealthcheckConfig       `yaml:"healthcheck,omitempty" json:"healthcheck" hash:"version:2"`
+
+	nextRun     *time.Time `hash:"-"`
+	lastRun     *time.Time `hash:"-"`
+	pendingRuns int        `hash:"-"`
+	attempt     int        `hash:"-"`
+
+	loc     *time.Location `hash:"-"`
+	windows []updateWindow `hash:"-"`
 }
 
 // PortConfig maps container ports to host ports
@@ -45,30 +86,21 @@ type PortConfig struct {
 
 // LoadConfigFromURL retrieves a Config object from a remote URL
 func LoadConfigFromURL(url string) (Config, error) {
-	resp, err := http.Get(url)
+	src, err := NewHTTPSource(url, DefaultSourceOptions())
 	if err != nil {
-		return nil, fmt.Errorf("Unable to fetch config from URL: %s", err)
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to read HTTP body: %s", err)
+		return nil, err
 	}
 
-	return parseConfig(body)
+	return LoadConfigFromSource(src)
 }
 
 // LoadConfigFromFile retrieves a Config object from a local file
 func LoadConfigFromFile(filename string) (Config, error) {
-	body, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to read config from file %q: %s", filename, err)
-	}
-
-	return parseConfig(body)
+	return LoadConfigFromSource(NewFileSource(filename))
 }
 
-func parseConfig(body []byte) (Config, error) {
+// ParseConfig parses and validates the raw YAML configuration
+func ParseConfig(body []byte) (Config, error) {
 	result := make(Config)
 
 	err := yaml.Unmarshal(body, &result)
@@ -77,26 +109,99 @@ func parseConfig(body []byte) (Config, error) {
 	}
 
 	for k := range result {
+		if err := result[k].validateTiming(); err != nil {
+			return nil, fmt.Errorf("Invalid timing for container %q: %s", k, err)
+		}
+
 		if err := result[k].UpdateNextRun(); err != nil {
 			return nil, fmt.Errorf("Unable to update next run: %s", err)
 		}
+
+		if err := result[k].validateUpdateTimes(); err != nil {
+			return nil, fmt.Errorf("Invalid update_times for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateUpdatePolicy(); err != nil {
+			return nil, fmt.Errorf("Invalid update_policy for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateUpdateStrategy(); err != nil {
+			return nil, fmt.Errorf("Invalid update_strategy for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateCatchUp(); err != nil {
+			return nil, fmt.Errorf("Invalid catch_up for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateJobLimits(); err != nil {
+			return nil, fmt.Errorf("Invalid job settings for container %q: %s", k, err)
+		}
+
+		if err := result[k].Secrets.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid secrets for container %q: %s", k, err)
+		}
+
+		if err := result[k].Hooks.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid hooks for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateInitContainers(); err != nil {
+			return nil, fmt.Errorf("Invalid init_containers for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateReplicas(k); err != nil {
+			return nil, fmt.Errorf("Invalid replicas for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateRuntime(); err != nil {
+			return nil, fmt.Errorf("Invalid runtime options for container %q: %s", k, err)
+		}
+
+		if err := result[k].validateDNS(); err != nil {
+			return nil, fmt.Errorf("Invalid DNS settings for container %q: %s", k, err)
+		}
+
+		if err := result[k].CheckSecurityPolicy(); err != nil {
+			return nil, fmt.Errorf("Container %q violates the security policy: %s", k, err)
+		}
+
+		if err := result[k].validateLogging(); err != nil {
+			return nil, fmt.Errorf("Invalid logging for container %q: %s", k, err)
+		}
+
+		if err := result[k].Healthcheck.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid healthcheck for container %q: %s", k, err)
+		}
+	}
+
+	if err := result.checkInstanceNames(); err != nil {
+		return nil, err
+	}
+
+	if err := result.checkReplicaLinks(); err != nil {
+		return nil, err
+	}
+
+	if err := result.checkPortConflicts(); err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
+// UpdateNextRun calculates the next run of scheduled containers from now
 func (c *ContainerConfig) UpdateNextRun() error {
 	if c.StartTimes == "" {
 		c.nextRun = nil
 		return nil
 	}
 
-	schedule, err := cron.Parse("0 " + c.StartTimes)
+	schedule, err := c.schedule()
 	if err != nil {
-		return fmt.Errorf("Invalid start_times %q: %s", c.StartTimes, err)
+		return err
 	}
 
-	nxt := schedule.Next(time.Now())
+	nxt := schedule.Next(time.Now().In(c.location())).Add(c.jitter())
 	c.nextRun = &nxt
 
 	return nil
@@ -112,40 +217,6 @@ func (c ContainerConfig) ShouldBeRunning(hostname string) bool {
 	return c.nextRun == nil || c.nextRun.Before(time.Now())
 }
 
-// Checksum generates a hash over the ContainerConfig to compare it to older versions
-func (c ContainerConfig) Checksum() (string, error) {
-	return fmt.Sprintf("%x", structhash.Sha1(c, 1)), nil
-}
-
-// UpdateAllowedAt checks whether a container may be updated at the given time
-func (c ContainerConfig) UpdateAllowedAt(pit time.Time) (bool, error) {
-	if len(c.UpdateTimes) == 0 {
-		return true, nil
-	}
-
-	for _, timeFrame := range c.UpdateTimes {
-		times := strings.Split(timeFrame, "-")
-		if len(times) != 2 {
-			continue
-		}
-
-		day := pit.Format("2006-01-02")
-		timezone := pit.Format("-0700")
-
-		t1, et1 := time.Parse("2006-01-02 15:04 -0700", fmt.Sprintf("%s %s %s", day, times[0], timezone))
-		t2, et2 := time.Parse("2006-01-02 15:04 -0700", fmt.Sprintf("%s %s %s", day, times[1], timezone))
-		if et1 != nil || et2 != nil {
-			return false, fmt.Errorf("Timeframe '%s' is invalid. Format is HH:MM-HH:MM", timeFrame)
-		}
-
-		if t1.Before(pit) && t2.After(pit) {
-			return true, nil
-		}
-	}
-
-	return false, nil
-}
-
 func (c ContainerConfig) GetDependencies() []string {
 	deps := c.DependsOn
 
@@ -168,13 +239,13 @@ func (c Config) GetDependencyChain() ([]string, error) {
 
 		// Detect whether we changed something
 		iterationChangedChain := false
-		for name, cfg := range c {
+		for name := range c {
 			// Already in the chain? Skip it.
 			if str.StringInSlice(name, chain) {
 				continue
 			}
 
-			deps := cfg.GetDependencies()
+			deps := c.GetConfigDependencies(name)
 			if len(deps) == 0 {
 				// Doesn't has dependencies? Great, off to the chain!
 				chain = append(chain, name)
@@ -213,6 +284,18 @@ func (c Config) GetImageList() []string {
 
 	for _, cont := range c {
 		images = append(images, fmt.Sprintf("%s:%s", cont.Image, cont.Tag))
+
+		// Images used between runs need to be kept by the image cleanup
+		for _, ic := range cont.InitContainers {
+			images = append(images, fmt.Sprintf("%s:%s", ic.Image, ic.ImageTag()))
+		}
+		for _, hooks := range [][]HookConfig{cont.Hooks.PreStop, cont.Hooks.PostStart, cont.Hooks.PreUpdate} {
+			for _, h := range hooks {
+				if img := h.ImageName(); img != "" {
+					images = append(images, img)
+				}
+			}
+		}
 	}
 
 	return images
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// extraHostTemplateFuncs are available in templated extra hosts.
+// Templates are rendered on the host starting the container.
+var extraHostTemplateFuncs = template.FuncMap{
+	"add":         func(a, b int) int { return a + b },
+	"interfaceIP": interfaceIP,
+}
+
+// RenderExtraHosts renders the extra hosts for the given replica index
+// and checks them to be in form `<hostname>:<ip>`
+func (c ContainerConfig) RenderExtraHosts(name string, index int) ([]string, error) {
+	hosts := []string{}
+	for _, h := range c.ExtraHosts {
+		v, err := renderTemplate(h, name, index, extraHostTemplateFuncs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateExtraHost(v); err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, v)
+	}
+	return hosts, nil
+}
+
+func (c ContainerConfig) validateDNS() error {
+	for _, d := range c.DNS {
+		if net.ParseIP(d) == nil {
+			return fmt.Errorf("DNS server %q is no IP address", d)
+		}
+	}
+
+	for _, h := range c.ExtraHosts {
+		if strings.Contains(h, "{{") {
+			// Result depends on the host, only check the template
+			if _, err := template.New("extra_host").Funcs(extraHostTemplateFuncs).Parse(h); err != nil {
+				return fmt.Errorf("Unable to parse template %q: %s", h, err)
+			}
+			continue
+		}
+
+		if err := validateExtraHost(h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateExtraHost(h string) error {
+	parts := strings.SplitN(h, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+		return fmt.Errorf("Extra host %q is not in form <hostname>:<ip>", h)
+	}
+	return nil
+}
+
+// interfaceIP returns the first IPv4 address of the given network
+// interface of the host
+func interfaceIP(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("Interface %q has no IPv4 address", name)
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HealthcheckConfig overrides the HEALTHCHECK of the image. The test is
+// given in the format of the Docker API: `["CMD", "curl", "-f", "..."]`,
+// `["CMD-SHELL", "curl -f ... || exit 1"]` or `["NONE"]` to disable the
+// health check of the image.
+type HealthcheckConfig struct {
+	Test     []string `yaml:"test,omitempty" json:"test"`
+	Interval string   `yaml:"interval,omitempty" json:"interval"`
+	Timeout  string   `yaml:"timeout,omitempty" json:"timeout"`
+	Retries  int      `yaml:"retries,omitempty" json:"retries"`
+}
+
+// Durations parses the interval and timeout of the health check. Zero
+// durations inherit the settings of the image.
+func (h HealthcheckConfig) Durations() (interval, timeout time.Duration, err error) {
+	if h.Interval != "" {
+		if interval, err = time.ParseDuration(h.Interval); err != nil {
+			return 0, 0, fmt.Errorf("Interval %q is invalid", h.Interval)
+		}
+	}
+
+	if h.Timeout != "" {
+		if timeout, err = time.ParseDuration(h.Timeout); err != nil {
+			return 0, 0, fmt.Errorf("Timeout %q is invalid", h.Timeout)
+		}
+	}
+
+	return interval, timeout, nil
+}
+
+func (h HealthcheckConfig) validate() error {
+	if len(h.Test) > 0 {
+		switch h.Test[0] {
+		case "NONE", "CMD", "CMD-SHELL":
+		default:
+			return errors.New("Test needs to start with CMD, CMD-SHELL or NONE")
+		}
+	}
+
+	if h.Retries < 0 {
+		return errors.New("Retries must not be negative")
+	}
+
+	_, _, err := h.Durations()
+	return err
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Types of hooks
+const (
+	HookTypeExec      = "exec"
+	HookTypeContainer = "container"
+)
+
+// Failure policies of hooks
+const (
+	HookFailureIgnore = "ignore"
+	HookFailureAbort  = "abort"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// HooksConfig contains the lifecycle hooks of a container
+type HooksConfig struct {
+	PreStop   []HookConfig `yaml:"pre_stop,omitempty" json:"pre_stop"`
+	PostStart []HookConfig `yaml:"post_start,omitempty" json:"post_start"`
+	PreUpdate []HookConfig `yaml:"pre_update,omitempty" json:"pre_update"`
+}
+
+// HookConfig describes a single command to be executed either inside
+// the container (exec) or in a helper container sharing the volumes of
+// the container (container)
+type HookConfig struct {
+	Command     []string `yaml:"command" json:"command"`
+	Type        string   `yaml:"type,omitempty" json:"type"`
+	Image       string   `yaml:"image,omitempty" json:"image"`
+	Tag         string   `yaml:"tag,omitempty" json:"tag"`
+	Environment []string `yaml:"environment,omitempty" json:"environment"`
+	Timeout     string   `yaml:"timeout,omitempty" json:"timeout"`
+	OnFailure   string   `yaml:"on_failure,omitempty" json:"on_failure"`
+}
+
+// Validate checks the hook definitions for obvious mistakes
+func (h HooksConfig) Validate() error {
+	for name, hooks := range map[string][]HookConfig{
+		"pre_stop":   h.PreStop,
+		"post_start": h.PostStart,
+		"pre_update": h.PreUpdate,
+	} {
+		for i, hook := range hooks {
+			if err := hook.validate(); err != nil {
+				return fmt.Errorf("Hook %d of %s: %s", i+1, name, err)
+			}
+
+			if name == "post_start" && hook.OnFailure == HookFailureAbort {
+				return fmt.Errorf("Hook %d of %s: There is nothing to abort after the start", i+1, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h HookConfig) validate() error {
+	if len(h.Command) == 0 {
+		return errors.New("No command specified")
+	}
+
+	switch h.Type {
+	case "", HookTypeExec:
+		if h.Image != "" {
+			return errors.New("Image can only be specified for hooks of type container")
+		}
+	case HookTypeContainer:
+	default:
+		return fmt.Errorf("Unknown type %q", h.Type)
+	}
+
+	switch h.OnFailure {
+	case "", HookFailureIgnore, HookFailureAbort:
+	default:
+		return fmt.Errorf("Unknown failure policy %q", h.OnFailure)
+	}
+
+	if _, err := h.TimeoutDuration(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ImageTag returns the tag of the hook image to use (default: latest)
+func (h HookConfig) ImageTag() string {
+	if h.Tag == "" {
+		return "latest"
+	}
+	return h.Tag
+}
+
+// ImageName returns the image of a container hook including its tag or
+// an empty string if the image of the container is used
+func (h HookConfig) ImageName() string {
+	if h.Image == "" {
+		return ""
+	}
+	return h.Image + ":" + h.ImageTag()
+}
+
+// TimeoutDuration returns the maximum runtime of the hook (default 30s)
+func (h HookConfig) TimeoutDuration() (time.Duration, error) {
+	if h.Timeout == "" {
+		return defaultHookTimeout, nil
+	}
+
+	d, err := time.ParseDuration(h.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Timeout %q is invalid", h.Timeout)
+	}
+	return d, nil
+}
+
+// AbortOnFailure tells whether a failure of the hook prevents the
+// action it belongs to
+func (h HookConfig) AbortOnFailure() bool { return h.OnFailure == HookFailureAbort }
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultInitTimeout = 10 * time.Minute
+
+// InitContainerConfig describes a one-shot container which needs to
+// exit successfully before the main container is started
+type InitContainerConfig struct {
+	Image       string   `yaml:"image" json:"image"`
+	Tag         string   `yaml:"tag,omitempty" json:"tag"`
+	Command     []string `yaml:"command,omitempty" json:"command"`
+	Volumes     []string `yaml:"volumes,omitempty" json:"volumes"`
+	Environment []string `yaml:"environment,omitempty" json:"environment"`
+	Timeout     string   `yaml:"timeout,omitempty" json:"timeout" hash:"-"`
+}
+
+// ImageTag returns the tag of the image to use (default: latest)
+func (i InitContainerConfig) ImageTag() string {
+	if i.Tag == "" {
+		return "latest"
+	}
+	return i.Tag
+}
+
+// TimeoutDuration returns the maximum runtime of the init container
+// (default 10m)
+func (i InitContainerConfig) TimeoutDuration() (time.Duration, error) {
+	if i.Timeout == "" {
+		return defaultInitTimeout, nil
+	}
+
+	d, err := time.ParseDuration(i.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Timeout %q is invalid", i.Timeout)
+	}
+	return d, nil
+}
+
+func (c ContainerConfig) validateInitContainers() error {
+	for i, ic := range c.InitContainers {
+		if ic.Image == "" {
+			return fmt.Errorf("Init container %d has no image", i+1)
+		}
+		if _, err := ic.TimeoutDuration(); err != nil {
+			return fmt.Errorf("Init container %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestImageListContainsHelperImages(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+web:
+  hosts: [ALL]
+  image: nginx
+  tag: "1.13"
+  init_containers:
+    - image: busybox
+      timeout: 1m
+  hooks:
+    pre_stop:
+      - type: container
+        image: alpine
+        tag: "3.7"
+        command: [sync]
+      - command: [nginx, -s, quit]
+`))
+	if err != nil {
+		t.Fatalf("Unable to parse config: %s", err)
+	}
+
+	images := cfg.GetImageList()
+	sort.Strings(images)
+	if expected := []string{"alpine:3.7", "busybox:latest", "nginx:1.13"}; !reflect.DeepEqual(images, expected) {
+		t.Errorf("Unexpected image list %v, expected %v", images, expected)
+	}
+}
+
+func TestInitContainerTimeout(t *testing.T) {
+	c := ContainerConfig{
+		Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest",
+		InitContainers: []InitContainerConfig{{Image: "busybox"}},
+	}
+	before, _ := c.Checksum()
+
+	c.InitContainers[0].Timeout = "1m"
+	if after, _ := c.Checksum(); after != before {
+		t.Errorf("Changed timeout changed the checksum")
+	}
+
+	c.InitContainers[0].Timeout = "soon"
+	if err := c.validateInitContainers(); err == nil {
+		t.Errorf("Invalid timeout was accepted")
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoggingConfig describes the logging driver of a container
+type LoggingConfig struct {
+	Driver  string            `yaml:"driver,omitempty" json:"driver"`
+	Options map[string]string `yaml:"options,omitempty" json:"options"`
+}
+
+var defaultLogging LoggingConfig
+
+// SetDefaultLogging sets the logging driver and options used for
+// containers not specifying their own driver. Options are given in form
+// `key=value`.
+func SetDefaultLogging(driver string, options []string) error {
+	l := LoggingConfig{Driver: driver, Options: map[string]string{}}
+
+	for _, o := range options {
+		if o == "" {
+			continue
+		}
+
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Log option %q is not in form key=value", o)
+		}
+		l.Options[parts[0]] = parts[1]
+	}
+
+	if l.Driver == "" && len(l.Options) > 0 {
+		return fmt.Errorf("Log options require a log driver")
+	}
+
+	defaultLogging = l
+	return nil
+}
+
+// EffectiveLogging returns the logging configuration to use for the
+// container: If the container specifies a driver its configuration is
+// used as is, if it only specifies options they are merged into the
+// options of the default driver.
+func (c ContainerConfig) EffectiveLogging() LoggingConfig {
+	if c.Logging.Driver != "" {
+		return c.Logging
+	}
+
+	l := LoggingConfig{Driver: defaultLogging.Driver, Options: map[string]string{}}
+	for k, v := range defaultLogging.Options {
+		l.Options[k] = v
+	}
+	for k, v := range c.Logging.Options {
+		l.Options[k] = v
+	}
+
+	return l
+}
+
+func (c ContainerConfig) validateLogging() error {
+	if c.Logging.Driver == "" && len(c.Logging.Options) > 0 && defaultLogging.Driver == "" {
+		return fmt.Errorf("Log options require a log driver to be set in the config or through --container-log-driver")
+	}
+	return nil
+}
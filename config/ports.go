@@ -0,0 +1,275 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Luzifer/go_helpers/str"
+)
+
+// PortBinding is a single binding of a container port to the host.
+// An empty HostPort lets docker choose a random port.
+type PortBinding struct {
+	ContainerPort string
+	HostIP        string
+	HostPort      string
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface to support the
+// short syntax `[[<ip>:]<host port>:]<container port>[/<protocol>]`
+func (p *PortConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err == nil {
+		return p.parseShort(short)
+	}
+
+	type plain PortConfig
+	return unmarshal((*plain)(p))
+}
+
+func (p *PortConfig) parseShort(in string) error {
+	spec, proto := in, ""
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		spec, proto = spec[:i], spec[i:]
+	}
+
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		// Only the container port, host port is chosen randomly
+		p.Container = spec + proto
+		return nil
+	}
+
+	p.Container = spec[i+1:] + proto
+	p.Local = spec[:i]
+	return nil
+}
+
+// Bindings parses the port configuration into the single port bindings.
+// Port ranges are expanded and `tcp+udp` creates bindings for both
+// protocols.
+func (p PortConfig) Bindings() ([]PortBinding, error) {
+	cPorts, protos, err := parsePortProto(p.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	hostIP, hostPortSpec, err := splitHostIP(p.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	var hPorts []int
+	if hostPortSpec != "" && hostPortSpec != "0" {
+		if hPorts, err = parsePortRange(hostPortSpec); err != nil {
+			return nil, fmt.Errorf("Local %q: %s", p.Local, err)
+		}
+		if len(hPorts) != len(cPorts) {
+			return nil, fmt.Errorf("Port ranges %q and %q differ in size", p.Local, p.Container)
+		}
+	}
+
+	bindings := []PortBinding{}
+	for _, proto := range protos {
+		for i, cp := range cPorts {
+			b := PortBinding{
+				ContainerPort: fmt.Sprintf("%d/%s", cp, proto),
+				HostIP:        hostIP,
+			}
+			if hPorts != nil {
+				b.HostPort = strconv.Itoa(hPorts[i])
+			}
+			bindings = append(bindings, b)
+		}
+	}
+
+	return bindings, nil
+}
+
+// splitHostIP splits the local part into IP and port (range). IPv6
+// addresses need to be enclosed in brackets (`[::1]:80`).
+func splitHostIP(local string) (string, string, error) {
+	if strings.HasPrefix(local, "[") {
+		end := strings.Index(local, "]")
+		if end < 0 {
+			return "", "", fmt.Errorf("Local %q has an unterminated IPv6 address", local)
+		}
+
+		ip, rest := local[1:end], local[end+1:]
+		if net.ParseIP(ip) == nil {
+			return "", "", fmt.Errorf("Local %q has an invalid IP address", local)
+		}
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("Local %q is invalid", local)
+		}
+		return ip, strings.TrimPrefix(rest, ":"), nil
+	}
+
+	if i := strings.LastIndex(local, ":"); i >= 0 {
+		ip := local[:i]
+		if ip != "" && (net.ParseIP(ip) == nil || strings.Contains(ip, ":")) {
+			return "", "", fmt.Errorf("Local %q has an invalid IP address (IPv6 needs brackets)", local)
+		}
+		return ip, local[i+1:], nil
+	}
+
+	if net.ParseIP(local) != nil {
+		// Only the IP was given, host port is chosen randomly
+		return local, "", nil
+	}
+
+	return "", local, nil
+}
+
+func parsePortProto(in string) ([]int, []string, error) {
+	spec, proto := in, "tcp"
+	if i := strings.Index(in, "/"); i >= 0 {
+		spec, proto = in[:i], strings.ToLower(in[i+1:])
+	}
+
+	protos := []string{}
+	for _, p := range strings.Split(proto, "+") {
+		switch p {
+		case "tcp", "udp", "sctp":
+			if !str.StringInSlice(p, protos) {
+				protos = append(protos, p)
+			}
+		default:
+			return nil, nil, fmt.Errorf("Container port %q has unknown protocol %q", in, p)
+		}
+	}
+
+	ports, err := parsePortRange(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Container port %q: %s", in, err)
+	}
+
+	return ports, protos, nil
+}
+
+func parsePortRange(in string) ([]int, error) {
+	parts := strings.SplitN(in, "-", 2)
+
+	start, err := parsePort(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	end := start
+	if len(parts) == 2 {
+		if end, err = parsePort(parts[1]); err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("Port range %q is reversed", in)
+		}
+	}
+
+	ports := []int{}
+	for p := start; p <= end; p++ {
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+func parsePort(in string) (int, error) {
+	p, err := strconv.Atoi(in)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("Port %q is invalid", in)
+	}
+	return p, nil
+}
+
+// HostPortBindings returns all bindings of the container having a
+// fixed host port
+func (c ContainerConfig) HostPortBindings(name string) ([]PortBinding, error) {
+	bindings := []PortBinding{}
+	for _, i := range c.InstanceIndexes() {
+		ports, err := c.ReplicaPorts(name, i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range ports {
+			b, err := p.Bindings()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, pb := range b {
+				if pb.HostPort != "" {
+					bindings = append(bindings, pb)
+				}
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// checkPortConflicts detects host ports bound by multiple containers
+// which might run on the same host
+func (c Config) checkPortConflicts() error {
+	type owner struct {
+		name    string
+		binding PortBinding
+	}
+
+	seen := map[string][]owner{}
+	for name, cfg := range c {
+		if cfg.StartTimes != "" && cfg.Concurrency == ConcurrencyAllow {
+			// Parallel runs of this job would conflict anyway
+			continue
+		}
+
+		bindings, err := cfg.HostPortBindings(name)
+		if err != nil {
+			return fmt.Errorf("Invalid ports for container %q: %s", name, err)
+		}
+
+		for _, b := range bindings {
+			proto := b.ContainerPort[strings.Index(b.ContainerPort, "/"):]
+			key := b.HostPort + proto
+
+			for _, o := range seen[key] {
+				if !sameHostIP(o.binding.HostIP, b.HostIP) || !c.shareHosts(o.name, name) {
+					continue
+				}
+				return fmt.Errorf("Containers %q and %q both bind host port %s", o.name, name, key)
+			}
+
+			seen[key] = append(seen[key], owner{name, b})
+		}
+	}
+
+	return nil
+}
+
+// sameHostIP checks whether two host IPs might overlap. An empty IP
+// binds on all addresses, unspecified IPs on all addresses of their
+// family.
+func sameHostIP(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+
+	ipa, ipb := net.ParseIP(a), net.ParseIP(b)
+	if (ipa.To4() == nil) != (ipb.To4() == nil) {
+		return false
+	}
+
+	return ipa.IsUnspecified() || ipb.IsUnspecified() || ipa.Equal(ipb)
+}
+
+// shareHosts checks whether the two containers might run on the same host
+func (c Config) shareHosts(a, b string) bool {
+	for _, ha := range c[a].Hosts {
+		for _, hb := range c[b].Hosts {
+			if ha == hb || ha == "ALL" || hb == "ALL" {
+				return true
+			}
+		}
+	}
+	return false
+}
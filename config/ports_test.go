@@ -0,0 +1,178 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		ports []int
+		err   bool
+	}{
+		{in: "80", ports: []int{80}},
+		{in: "8000-8002", ports: []int{8000, 8001, 8002}},
+		{in: "65535", ports: []int{65535}},
+		{in: "0", err: true},
+		{in: "65536", err: true},
+		{in: "http", err: true},
+		{in: "8002-8000", err: true},
+		{in: "8000-", err: true},
+	} {
+		ports, err := parsePortRange(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if !tc.err && !reflect.DeepEqual(ports, tc.ports) {
+			t.Errorf("%q: got %v, expected %v", tc.in, ports, tc.ports)
+		}
+	}
+}
+
+func TestSplitHostIP(t *testing.T) {
+	for _, tc := range []struct {
+		in, ip, port string
+		err          bool
+	}{
+		{in: "", ip: "", port: ""},
+		{in: "8080", ip: "", port: "8080"},
+		{in: ":8080", ip: "", port: "8080"},
+		{in: "127.0.0.1", ip: "127.0.0.1", port: ""},
+		{in: "127.0.0.1:8080", ip: "127.0.0.1", port: "8080"},
+		{in: "0.0.0.0:8000-8010", ip: "0.0.0.0", port: "8000-8010"},
+		{in: "[::1]:53", ip: "::1", port: "53"},
+		{in: "[::1]", ip: "::1", port: ""},
+		{in: "::1", err: true},
+		{in: "::1:53", err: true},
+		{in: "[::1:53", err: true},
+		{in: "[::1]53", err: true},
+		{in: "[foo]:53", err: true},
+		{in: "foo:53", err: true},
+	} {
+		ip, port, err := splitHostIP(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error state: %v", tc.in, err)
+			continue
+		}
+		if !tc.err && (ip != tc.ip || port != tc.port) {
+			t.Errorf("%q: got %q / %q, expected %q / %q", tc.in, ip, port, tc.ip, tc.port)
+		}
+	}
+}
+
+func TestParseShort(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected PortConfig
+	}{
+		{"80", PortConfig{Container: "80"}},
+		{"53/udp", PortConfig{Container: "53/udp"}},
+		{"8080:80", PortConfig{Container: "80", Local: "8080"}},
+		{"0.0.0.0:80:80/tcp", PortConfig{Container: "80/tcp", Local: "0.0.0.0:80"}},
+		{"[::1]:53:53/udp", PortConfig{Container: "53/udp", Local: "[::1]:53"}},
+		{"127.0.0.1::80", PortConfig{Container: "80", Local: "127.0.0.1:"}},
+		{"8000-8010:8000-8010", PortConfig{Container: "8000-8010", Local: "8000-8010"}},
+	} {
+		p := PortConfig{}
+		if err := p.parseShort(tc.in); err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.in, err)
+			continue
+		}
+		if p != tc.expected {
+			t.Errorf("%q: got %+v, expected %+v", tc.in, p, tc.expected)
+		}
+	}
+}
+
+func TestCheckPortConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, err string
+	}{
+		{
+			name: "different ports",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["80:80"]}
+b: {hosts: [ALL], image: nginx, ports: ["81:80"]}
+`,
+		},
+		{
+			name: "same port",
+			err:  "both bind host port 80/tcp",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["80:80"]}
+b: {hosts: [ALL], image: nginx, ports: ["80:80"]}
+`,
+		},
+		{
+			name: "different protocols",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["53:53/tcp"]}
+b: {hosts: [ALL], image: nginx, ports: ["53:53/udp"]}
+`,
+		},
+		{
+			name: "different hosts",
+			config: `
+a: {hosts: [host1], image: nginx, ports: ["80:80"]}
+b: {hosts: [host2], image: nginx, ports: ["80:80"]}
+`,
+		},
+		{
+			name: "different addresses",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["127.0.0.1:80:80"]}
+b: {hosts: [ALL], image: nginx, ports: ["127.0.0.2:80:80"]}
+`,
+		},
+		{
+			name: "all addresses",
+			err:  "both bind host port 80/tcp",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["0.0.0.0:80:80"]}
+b: {hosts: [ALL], image: nginx, ports: ["127.0.0.1:80:80"]}
+`,
+		},
+		{
+			name: "different address families",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["0.0.0.0:80:80"]}
+b: {hosts: [ALL], image: nginx, ports: ["[::]:80:80"]}
+`,
+		},
+		{
+			name: "overlapping ranges",
+			err:  "both bind host port 8005/tcp",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["8000-8005:8000-8005"]}
+b: {hosts: [ALL], image: nginx, ports: ["8005:80"]}
+`,
+		},
+		{
+			name: "replicas",
+			err:  "both bind host port 80/tcp",
+			config: `
+a: {hosts: [ALL], image: nginx, replicas: 2, ports: ["80:80"]}
+`,
+		},
+		{
+			name: "random ports",
+			config: `
+a: {hosts: [ALL], image: nginx, ports: ["80"]}
+b: {hosts: [ALL], image: nginx, ports: ["80"]}
+`,
+		},
+	} {
+		_, err := ParseConfig([]byte(tc.config))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: Unexpected error: %s", tc.name, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%s: Expected error containing %q", tc.name, tc.err)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%s: Error %q does not contain %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Luzifer/go_helpers/str"
+)
+
+// replicaTemplateFuncs are available in templated environment
+// variables and ports of replicated containers
+var replicaTemplateFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}
+
+// replicaTemplateData is passed to the templates of a replica
+type replicaTemplateData struct {
+	Index int
+	Name  string
+}
+
+// InstanceName returns the name of the container for the given replica
+// index. Index 0 is used for containers not having replicas.
+func InstanceName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	return name + "-" + strconv.Itoa(index)
+}
+
+// InstanceIndexes returns the replica indexes of the containers to run
+// for this configuration
+func (c ContainerConfig) InstanceIndexes() []int {
+	if c.Replicas == 0 {
+		return []int{0}
+	}
+
+	idx := make([]int, c.Replicas)
+	for i := range idx {
+		idx[i] = i + 1
+	}
+	return idx
+}
+
+// InstanceNames returns the names of the containers to run for this
+// configuration
+func (c ContainerConfig) InstanceNames(name string) []string {
+	names := []string{}
+	for _, i := range c.InstanceIndexes() {
+		names = append(names, InstanceName(name, i))
+	}
+	return names
+}
+
+// ReplicaEnvironment renders the environment variables for the given
+// replica index
+func (c ContainerConfig) ReplicaEnvironment(name string, index int) ([]string, error) {
+	if index == 0 {
+		return c.Environment, nil
+	}
+
+	env := []string{}
+	for _, e := range c.Environment {
+		v, err := renderReplicaTemplate(e, name, index)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, v)
+	}
+	return env, nil
+}
+
+// ReplicaPorts renders the port configurations for the given replica
+// index
+func (c ContainerConfig) ReplicaPorts(name string, index int) ([]PortConfig, error) {
+	if index == 0 {
+		return c.Ports, nil
+	}
+
+	ports := []PortConfig{}
+	for _, p := range c.Ports {
+		local, err := renderReplicaTemplate(p.Local, name, index)
+		if err != nil {
+			return nil, err
+		}
+		cont, err := renderReplicaTemplate(p.Container, name, index)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, PortConfig{Container: cont, Local: local})
+	}
+	return ports, nil
+}
+
+func renderReplicaTemplate(in, name string, index int) (string, error) {
+	return renderTemplate(in, name, index, replicaTemplateFuncs)
+}
+
+func renderTemplate(in, name string, index int, funcs template.FuncMap) (string, error) {
+	if !strings.Contains(in, "{{") {
+		return in, nil
+	}
+
+	tpl, err := template.New("replica").Funcs(funcs).Parse(in)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse template %q: %s", in, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, replicaTemplateData{Index: index, Name: InstanceName(name, index)}); err != nil {
+		return "", fmt.Errorf("Unable to render template %q: %s", in, err)
+	}
+	return buf.String(), nil
+}
+
+func (c ContainerConfig) validateReplicas(name string) error {
+	if c.Replicas < 0 {
+		return errors.New("Replicas must not be negative")
+	}
+
+	if c.Replicas > 0 && c.StartTimes != "" {
+		return errors.New("Replicas are not supported for scheduled containers")
+	}
+
+	for _, i := range c.InstanceIndexes() {
+		if _, err := c.ReplicaEnvironment(name, i); err != nil {
+			return err
+		}
+		if _, err := c.ReplicaPorts(name, i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkInstanceNames refuses configurations whose name is also used
+// for a replica or a parallel run of another configuration
+func (c Config) checkInstanceNames() error {
+	for name, cfg := range c {
+		for other := range c {
+			suffix := strings.TrimPrefix(other, name+"-")
+			if other == name || suffix == other || suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+				continue
+			}
+
+			if idx, err := strconv.Atoi(suffix); err == nil && idx >= 1 && idx <= cfg.Replicas {
+				return fmt.Errorf("Container %q collides with replica %d of container %q", other, idx, name)
+			}
+
+			if cfg.StartTimes != "" && cfg.Concurrency == ConcurrencyAllow {
+				return fmt.Errorf("Container %q collides with the parallel runs (%s-<timestamp>) of container %q", other, name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkReplicaLinks refuses links to the name of a replicated
+// configuration as no container with that name exists
+func (c Config) checkReplicaLinks() error {
+	for name, cfg := range c {
+		for _, lnk := range cfg.Links {
+			target := strings.SplitN(lnk, ":", 2)[0]
+			if t, ok := c[target]; ok && t.Replicas > 0 {
+				return fmt.Errorf("Container %q links to replicated container %q, link one of its replicas (e.g. %q) instead", name, target, InstanceName(target, 1))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ConfigNameOf resolves the name of a container (which might be a
+// replica) to the name of its configuration. If no configuration
+// matches the name is returned unchanged.
+func (c Config) ConfigNameOf(containerName string) string {
+	if _, ok := c[containerName]; ok {
+		return containerName
+	}
+
+	for name, cfg := range c {
+		if str.StringInSlice(containerName, cfg.InstanceNames(name)) {
+			return name
+		}
+	}
+
+	return containerName
+}
+
+// IsInstance checks whether the container name is one of the containers
+// to run for the given configuration
+func (c Config) IsInstance(name, containerName string) bool {
+	cfg, ok := c[name]
+	return ok && str.StringInSlice(containerName, cfg.InstanceNames(name))
+}
+
+// GetConfigDependencies returns the names of the configurations the
+// given configuration depends on
+func (c Config) GetConfigDependencies(name string) []string {
+	deps := []string{}
+	for _, d := range c[name].GetDependencies() {
+		deps = append(deps, c.ConfigNameOf(d))
+	}
+	return deps
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplicaNameConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, err string
+	}{
+		{
+			name: "distinct names",
+			config: `
+web:
+  hosts: [ALL]
+  image: nginx
+  replicas: 2
+web-3:
+  hosts: [ALL]
+  image: nginx
+`,
+		},
+		{
+			name: "replica collides",
+			err:  `collides with replica 2 of container "web"`,
+			config: `
+web:
+  hosts: [ALL]
+  image: nginx
+  replicas: 2
+web-2:
+  hosts: [ALL]
+  image: nginx
+`,
+		},
+		{
+			name: "parallel run collides",
+			err:  `collides with the parallel runs`,
+			config: `
+job:
+  hosts: [ALL]
+  image: busybox
+  start_times: "0 * * * *"
+  concurrency_policy: allow
+job-1500000000:
+  hosts: [ALL]
+  image: busybox
+`,
+		},
+		{
+			name: "link to replica",
+			config: `
+web:
+  hosts: [ALL]
+  image: nginx
+  replicas: 2
+proxy:
+  hosts: [ALL]
+  image: nginx
+  links: ["web-1:web"]
+`,
+		},
+		{
+			name: "link to replicated base name",
+			err:  `link one of its replicas (e.g. "web-1") instead`,
+			config: `
+web:
+  hosts: [ALL]
+  image: nginx
+  replicas: 2
+proxy:
+  hosts: [ALL]
+  image: nginx
+  links: ["web:web"]
+`,
+		},
+	} {
+		_, err := ParseConfig([]byte(tc.config))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: Unexpected error: %s", tc.name, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%s: Expected error containing %q", tc.name, tc.err)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%s: Error %q does not contain %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReplicasKeepChecksum(t *testing.T) {
+	c := ContainerConfig{Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest"}
+	before, _ := c.Checksum()
+
+	c.Replicas = 3
+	if after, _ := c.Checksum(); after != before {
+		t.Errorf("Scaling changed the checksum")
+	}
+}
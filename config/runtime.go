@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UlimitConfig describes the soft and hard limit of an ulimit. In the
+// YAML it can also be given as a single number for both limits.
+type UlimitConfig struct {
+	Soft int64 `yaml:"soft" json:"soft"`
+	Hard int64 `yaml:"hard" json:"hard"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (u *UlimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single int64
+	if err := unmarshal(&single); err == nil {
+		u.Soft, u.Hard = single, single
+		return nil
+	}
+
+	type plain UlimitConfig
+	return unmarshal((*plain)(u))
+}
+
+// DeviceConfig describes a device of the host to add to the container
+type DeviceConfig struct {
+	PathOnHost        string
+	PathInContainer   string
+	CgroupPermissions string
+}
+
+// ShmSizeBytes parses the shm_size (e.g. `64m`) into bytes. Zero is
+// returned if no size is set.
+func (c ContainerConfig) ShmSizeBytes() (int64, error) {
+	if c.ShmSize == "" {
+		return 0, nil
+	}
+	return parseByteSize(c.ShmSize)
+}
+
+// DeviceMappings parses the devices in form
+// `<host path>[:<container path>[:<permissions>]]`
+func (c ContainerConfig) DeviceMappings() ([]DeviceConfig, error) {
+	devices := []DeviceConfig{}
+	for _, d := range c.Devices {
+		parts := strings.Split(d, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("Device %q is invalid", d)
+		}
+
+		dev := DeviceConfig{
+			PathOnHost:        parts[0],
+			PathInContainer:   parts[0],
+			CgroupPermissions: "rwm",
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			dev.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			dev.CgroupPermissions = parts[2]
+		}
+
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+func (c ContainerConfig) validateRuntime() error {
+	if _, err := c.ShmSizeBytes(); err != nil {
+		return err
+	}
+
+	if _, err := c.DeviceMappings(); err != nil {
+		return err
+	}
+
+	for name, u := range c.Ulimits {
+		if u.Soft > u.Hard {
+			return fmt.Errorf("Soft limit of ulimit %q exceeds the hard limit", name)
+		}
+	}
+
+	return nil
+}
+
+func parseByteSize(in string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(in))
+	s = strings.TrimSuffix(s, "b")
+
+	mult := int64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("Size %q is invalid", in)
+	}
+	return v * mult, nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, err string
+	}{
+		{name: "init disabled", config: "web: {hosts: [ALL], image: nginx, init: false}"},
+		{name: "init enabled", config: "web: {hosts: [ALL], image: nginx, init: true}"},
+		{name: "shm size", config: "web: {hosts: [ALL], image: nginx, shm_size: 256m}"},
+		{name: "invalid shm size", config: "web: {hosts: [ALL], image: nginx, shm_size: huge}", err: `Size "huge" is invalid`},
+		{name: "invalid device", config: "web: {hosts: [ALL], image: nginx, devices: ['a:b:c:d']}", err: `Device "a:b:c:d" is invalid`},
+		{name: "ulimit", config: "web: {hosts: [ALL], image: nginx, ulimits: {nofile: {soft: 1, hard: 2}}}"},
+		{name: "reversed ulimit", config: "web: {hosts: [ALL], image: nginx, ulimits: {nofile: {soft: 2, hard: 1}}}", err: "exceeds the hard limit"},
+	} {
+		_, err := ParseConfig([]byte(tc.config))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: Unexpected error: %s", tc.name, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%s: Expected error containing %q", tc.name, tc.err)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%s: Error %q does not contain %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// Catch-up policies for runs of scheduled containers missed while the
+// dockermanager was not running
+const (
+	CatchUpSkip    = "skip"
+	CatchUpRunOnce = "run_once"
+	CatchUpRunAll  = "run_all"
+)
+
+// Concurrency policies for scheduled containers whose previous run is
+// still active when the next run is due
+const (
+	ConcurrencyForbid  = "forbid"
+	ConcurrencyReplace = "replace"
+	ConcurrencyAllow   = "allow"
+)
+
+const defaultRetryBackoff = time.Minute
+
+var (
+	defaultLocation = time.Local
+
+	jitterRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandLock sync.Mutex
+)
+
+// maxCatchUpRuns limits the number of runs executed by the run_all
+// policy to prevent a flood of runs after a long downtime
+const maxCatchUpRuns = 100
+
+// ScheduleState contains the scheduling information of a container
+// which needs to survive config reloads and daemon restarts
+type ScheduleState struct {
+	StartTimes  string     `json:"start_times"`
+	Timezone    string     `json:"timezone,omitempty"`
+	Jitter      string     `json:"jitter,omitempty"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	PendingRuns int        `json:"pending_runs,omitempty"`
+	Attempt     int        `json:"attempt,omitempty"`
+}
+
+// RetryConfig controls how often a failed run of a scheduled container
+// is retried before it is considered failed
+type RetryConfig struct {
+	Attempts int    `yaml:"attempts,omitempty" json:"attempts"`
+	Backoff  string `yaml:"backoff,omitempty" json:"backoff"`
+}
+
+// BackoffDuration returns the wait time before the first retry. The
+// wait time is doubled for every further retry.
+func (r RetryConfig) BackoffDuration() time.Duration {
+	if d, err := time.ParseDuration(r.Backoff); err == nil {
+		return d
+	}
+	return defaultRetryBackoff
+}
+
+// SetDefaultTimezone sets the timezone used for start_times and
+// update_times of containers not specifying their own timezone
+func SetDefaultTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("Unable to load timezone %q: %s", name, err)
+	}
+
+	defaultLocation = loc
+	return nil
+}
+
+// schedule parses the start_times of the container. Descriptors
+// (`@daily`, `@every 1h30m`) and six-field specs having seconds as
+// their first field are used as is, classic five-field cron specs
+// are run at second zero.
+func (c *ContainerConfig) schedule() (cron.Schedule, error) {
+	spec := c.StartTimes
+	if !strings.HasPrefix(spec, "@") && len(strings.Fields(spec)) < 6 {
+		spec = "0 " + spec
+	}
+
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid start_times %q: %s", c.StartTimes, err)
+	}
+	return schedule, nil
+}
+
+func (c *ContainerConfig) location() *time.Location {
+	if c.Timezone == "" {
+		return defaultLocation
+	}
+
+	if c.loc != nil {
+		return c.loc
+	}
+
+	if loc, err := time.LoadLocation(c.Timezone); err == nil {
+		return loc
+	}
+	return defaultLocation
+}
+
+// jitter returns a random delay between zero and the configured jitter
+func (c *ContainerConfig) jitter() time.Duration {
+	max, err := time.ParseDuration(c.Jitter)
+	if err != nil || max <= 0 {
+		return 0
+	}
+
+	jitterRandLock.Lock()
+	defer jitterRandLock.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
+
+func (c *ContainerConfig) validateTiming() error {
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("Unknown timezone %q", c.Timezone)
+		}
+		c.loc = loc
+	}
+
+	if c.Jitter != "" {
+		if d, err := time.ParseDuration(c.Jitter); err != nil || d < 0 {
+			return fmt.Errorf("Invalid jitter %q", c.Jitter)
+		}
+	}
+
+	return nil
+}
+
+func (c *ContainerConfig) validateCatchUp() error {
+	switch c.CatchUp {
+	case "", CatchUpSkip, CatchUpRunOnce, CatchUpRunAll:
+		return nil
+	}
+	return fmt.Errorf("Unknown policy %q", c.CatchUp)
+}
+
+func (c *ContainerConfig) validateJobLimits() error {
+	switch c.Concurrency {
+	case "", ConcurrencyForbid, ConcurrencyReplace, ConcurrencyAllow:
+	default:
+		return fmt.Errorf("Unknown concurrency_policy %q", c.Concurrency)
+	}
+
+	if c.Retry.Attempts < 0 {
+		return fmt.Errorf("Retry attempts must not be negative")
+	}
+
+	if c.Retry.Backoff != "" {
+		if d, err := time.ParseDuration(c.Retry.Backoff); err != nil || d <= 0 {
+			return fmt.Errorf("Invalid retry backoff %q", c.Retry.Backoff)
+		}
+	}
+
+	if c.MaxRuntime != "" {
+		if d, err := time.ParseDuration(c.MaxRuntime); err != nil || d <= 0 {
+			return fmt.Errorf("Invalid max_runtime %q", c.MaxRuntime)
+		}
+	}
+
+	return nil
+}
+
+// MaxRuntimeDuration returns the maximum runtime of a scheduled
+// container or zero if the runtime is not limited
+func (c *ContainerConfig) MaxRuntimeDuration() time.Duration {
+	d, _ := time.ParseDuration(c.MaxRuntime)
+	return d
+}
+
+// ScheduleState exports the current scheduling state of the container
+func (c *ContainerConfig) ScheduleState() ScheduleState {
+	return ScheduleState{
+		StartTimes:  c.StartTimes,
+		Timezone:    c.location().String(),
+		Jitter:      c.Jitter,
+		LastRun:     c.lastRun,
+		NextRun:     c.nextRun,
+		PendingRuns: c.pendingRuns,
+		Attempt:     c.attempt,
+	}
+}
+
+// scheduleChanged checks whether any of the inputs of the next run
+// calculation changed since the state was exported
+func (c *ContainerConfig) scheduleChanged(state ScheduleState) bool {
+	if state.StartTimes != c.StartTimes {
+		return true
+	}
+
+	if state.Timezone == "" {
+		// State was written by a version not storing timezone and jitter
+		return false
+	}
+
+	return state.Timezone != c.location().String() || state.Jitter != c.Jitter
+}
+
+// RestoreScheduleState takes over a previously exported state if the
+// schedule did not change. When catchUp is set runs missed in the past
+// are handled according to the configured catch_up policy, otherwise
+// the state is taken over as is.
+func (c *ContainerConfig) RestoreScheduleState(state ScheduleState, catchUp bool) error {
+	if c.StartTimes == "" || c.scheduleChanged(state) {
+		// Schedule changed, keep the freshly calculated next run
+		return nil
+	}
+
+	c.lastRun = state.LastRun
+	c.attempt = state.Attempt
+
+	if state.NextRun == nil || !catchUp || state.NextRun.After(time.Now()) {
+		if state.NextRun != nil {
+			c.nextRun = state.NextRun
+		}
+		c.pendingRuns = state.PendingRuns
+		return nil
+	}
+
+	switch c.CatchUp {
+	case CatchUpRunOnce:
+		c.nextRun = state.NextRun
+		c.pendingRuns = 0
+
+	case CatchUpRunAll:
+		schedule, err := c.schedule()
+		if err != nil {
+			return err
+		}
+
+		missed := state.PendingRuns
+		for t := *state.NextRun; !t.After(time.Now()) && missed < maxCatchUpRuns; t = schedule.Next(t) {
+			missed++
+		}
+
+		c.nextRun = state.NextRun
+		c.pendingRuns = missed - 1
+
+	default:
+		// Skip: The next run was already calculated from now
+		c.pendingRuns = 0
+	}
+
+	return nil
+}
+
+// MarkRun records a run of the scheduled container at the given time
+// and determines the next run
+func (c *ContainerConfig) MarkRun(t time.Time) error {
+	c.lastRun = &t
+
+	if c.pendingRuns > 0 {
+		// Catch up on missed runs: Due again as soon as this run is done
+		c.pendingRuns--
+		c.nextRun = &t
+		return nil
+	}
+
+	return c.UpdateNextRun()
+}
+
+// SkipRun moves the next run to the next slot without recording a run.
+// Queued catch-up runs are not skipped but wait for the next attempt,
+// in this case false is returned.
+func (c *ContainerConfig) SkipRun() (bool, error) {
+	if c.pendingRuns > 0 {
+		return false, nil
+	}
+
+	return true, c.UpdateNextRun()
+}
+
+// Attempt returns the number of the current retry of a failed run,
+// zero for the regular run
+func (c *ContainerConfig) Attempt() int { return c.attempt }
+
+// ScheduleRetry is called after a run failed. If retries are left the
+// next run is scheduled after the backoff and true is returned.
+// Otherwise the retry counter is reset and the next regular run is kept.
+func (c *ContainerConfig) ScheduleRetry(now time.Time) (bool, time.Time) {
+	if c.attempt >= c.Retry.Attempts {
+		c.attempt = 0
+		return false, time.Time{}
+	}
+
+	c.attempt++
+	next := now.Add(c.Retry.BackoffDuration() << uint(c.attempt-1))
+	c.nextRun = &next
+
+	return true, next
+}
+
+// ResetRetries is called after a successful run
+func (c *ContainerConfig) ResetRetries() { c.attempt = 0 }
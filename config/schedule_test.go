@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestoreScheduleState(t *testing.T) {
+	parse := func(cfg string) *ContainerConfig {
+		c, err := ParseConfig([]byte(cfg))
+		if err != nil {
+			t.Fatalf("Unable to parse config: %s", err)
+		}
+		return c["job"]
+	}
+
+	base := "job: {hosts: [ALL], image: busybox, start_times: '0 3 * * *', timezone: Europe/Berlin, jitter: 5m}\n"
+
+	lastRun := time.Now().Add(-time.Hour).Truncate(time.Second)
+	old := parse(base)
+	old.lastRun = &lastRun
+	state := old.ScheduleState()
+
+	legacy := state
+	legacy.Timezone, legacy.Jitter = "", ""
+
+	for _, tc := range []struct {
+		name     string
+		config   string
+		state    ScheduleState
+		restored bool
+	}{
+		{"unchanged", base, state, true},
+		{"start_times changed", "job: {hosts: [ALL], image: busybox, start_times: '0 4 * * *', timezone: Europe/Berlin, jitter: 5m}\n", state, false},
+		{"timezone changed", "job: {hosts: [ALL], image: busybox, start_times: '0 3 * * *', timezone: UTC, jitter: 5m}\n", state, false},
+		{"jitter changed", "job: {hosts: [ALL], image: busybox, start_times: '0 3 * * *', timezone: Europe/Berlin}\n", state, false},
+		{"state without timezone", base, legacy, true},
+	} {
+		c := parse(tc.config)
+		if err := c.RestoreScheduleState(tc.state, false); err != nil {
+			t.Fatalf("%s: Unable to restore state: %s", tc.name, err)
+		}
+
+		if restored := c.lastRun != nil && c.lastRun.Equal(lastRun); restored != tc.restored {
+			t.Errorf("%s: state restored = %v, expected %v", tc.name, restored, tc.restored)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const defaultSecretsPath = "/run/secrets"
+
+var (
+	openSSLSaltHeader = []byte("Salted__")
+
+	secretDigestKey []byte
+)
+
+// SetSecretDigestKey sets the host-local key the digests of the secret
+// contents in the checksum are calculated with. The checksum is visible
+// in the container labels, without a secret key weak secrets could be
+// guessed from it.
+func SetSecretDigestKey(key []byte) { secretDigestKey = key }
+
+// SecretsConfig describes a set of secrets to be written into files
+// and mounted read-only into the container
+type SecretsConfig struct {
+	Path  string         `yaml:"path,omitempty" json:"path"`
+	Files []SecretConfig `yaml:"files,omitempty" json:"files"`
+}
+
+// SecretConfig describes a single secret file and where to take its
+// content from. Exactly one of Value, File or Encrypted must be set.
+type SecretConfig struct {
+	Name      string `yaml:"name" json:"name"`
+	Value     string `yaml:"value,omitempty" json:"value" hash:"-"`
+	File      string `yaml:"file,omitempty" json:"file"`
+	Encrypted string `yaml:"encrypted,omitempty" json:"encrypted"`
+	Mode      string `yaml:"mode,omitempty" json:"mode"`
+	UID       *int   `yaml:"uid,omitempty" json:"uid"`
+	GID       *int   `yaml:"gid,omitempty" json:"gid"`
+}
+
+// Owner returns the configured owner of the secret file. Unset IDs are
+// returned as -1 to keep the owner of the daemon.
+func (s SecretConfig) Owner() (uid, gid int) {
+	uid, gid = -1, -1
+	if s.UID != nil {
+		uid = *s.UID
+	}
+	if s.GID != nil {
+		gid = *s.GID
+	}
+	return uid, gid
+}
+
+// MountPath returns the path inside the container the secrets are mounted to
+func (s SecretsConfig) MountPath() string {
+	if s.Path == "" {
+		return defaultSecretsPath
+	}
+	return s.Path
+}
+
+// Validate checks the secret definitions for obvious mistakes
+func (s SecretsConfig) Validate() error {
+	if !path.IsAbs(s.MountPath()) {
+		return fmt.Errorf("Secrets path %q needs to be absolute", s.MountPath())
+	}
+
+	seen := map[string]bool{}
+	for _, f := range s.Files {
+		if f.Name == "" || strings.Contains(f.Name, "/") || f.Name == "." || f.Name == ".." {
+			return fmt.Errorf("Secret name %q is invalid", f.Name)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("Secret %q is defined twice", f.Name)
+		}
+		seen[f.Name] = true
+
+		sources := 0
+		for _, v := range []string{f.Value, f.File, f.Encrypted} {
+			if v != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("Secret %q needs exactly one of value, file or encrypted", f.Name)
+		}
+
+		if _, err := f.FileMode(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contentDigests calculates keyed digests of the plain secret values
+// and the secrets read from files. Plain values must not end up in the
+// checksum and changes of the files are not visible in the config.
+func (s SecretsConfig) contentDigests() (map[string]string, error) {
+	digests := map[string]string{}
+	for _, f := range s.Files {
+		if f.Value == "" && f.File == "" {
+			continue
+		}
+
+		body, err := f.Content(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		mac := hmac.New(sha256.New, secretDigestKey)
+		mac.Write(body)
+		digests[f.Name] = fmt.Sprintf("%x", mac.Sum(nil))
+	}
+
+	return digests, nil
+}
+
+// FileMode parses the configured mode of the secret file (default 0400)
+func (s SecretConfig) FileMode() (os.FileMode, error) {
+	if s.Mode == "" {
+		return 0400, nil
+	}
+
+	var m uint32
+	if _, err := fmt.Sscanf(s.Mode, "%o", &m); err != nil || m > 0777 {
+		return 0, fmt.Errorf("Mode %q of secret %q is invalid", s.Mode, s.Name)
+	}
+	return os.FileMode(m), nil
+}
+
+// Content resolves the content of the secret. Encrypted secrets are
+// expected in the format produced by
+// `openssl enc -aes-256-cbc -md sha256 -a` and are decrypted using
+// the given passphrase.
+func (s SecretConfig) Content(passphrase []byte) ([]byte, error) {
+	switch {
+	case s.Value != "":
+		return []byte(s.Value), nil
+
+	case s.File != "":
+		body, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read secret file %q: %s", s.File, err)
+		}
+		return body, nil
+
+	case s.Encrypted != "":
+		if len(passphrase) == 0 {
+			return nil, errors.New("Secret is encrypted but no secrets key is available")
+		}
+		return decryptOpenSSL(s.Encrypted, passphrase)
+	}
+
+	return nil, fmt.Errorf("Secret %q has no content source", s.Name)
+}
+
+func decryptOpenSSL(encoded string, passphrase []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode encrypted secret: %s", err)
+	}
+
+	if len(data) < aes.BlockSize || !bytes.Equal(data[:8], openSSLSaltHeader) {
+		return nil, errors.New("Encrypted secret does not have an OpenSSL salt header")
+	}
+
+	salt, data := data[8:16], data[16:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("Encrypted secret has an invalid length")
+	}
+
+	key, iv := bytesToKey(passphrase, salt, 32, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create cipher: %s", err)
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+		return nil, errors.New("Unable to decrypt secret: Invalid padding (wrong key?)")
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("Unable to decrypt secret: Invalid padding (wrong key?)")
+		}
+	}
+
+	return out[:len(out)-pad], nil
+}
+
+// bytesToKey implements the OpenSSL EVP_BytesToKey derivation using SHA256
+func bytesToKey(passphrase, salt []byte, keyLen, ivLen int) ([]byte, []byte) {
+	var (
+		buf  []byte
+		prev []byte
+	)
+
+	for len(buf) < keyLen+ivLen {
+		h := sha256.New()
+		h.Write(prev)
+		h.Write(passphrase)
+		h.Write(salt)
+		prev = h.Sum(nil)
+		buf = append(buf, prev...)
+	}
+
+	return buf[:keyLen], buf[keyLen : keyLen+ivLen]
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cnf/structhash"
+)
+
+func TestSecretOwner(t *testing.T) {
+	zero, user := 0, 1000
+
+	for _, tc := range []struct {
+		name     string
+		secret   SecretConfig
+		uid, gid int
+	}{
+		{"unset", SecretConfig{}, -1, -1},
+		{"root", SecretConfig{UID: &zero, GID: &zero}, 0, 0},
+		{"uid only", SecretConfig{UID: &user}, 1000, -1},
+		{"gid only", SecretConfig{GID: &user}, -1, 1000},
+	} {
+		uid, gid := tc.secret.Owner()
+		if uid != tc.uid || gid != tc.gid {
+			t.Errorf("%s: got %d:%d, expected %d:%d", tc.name, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestSecretValueNotInChecksum(t *testing.T) {
+	defer SetSecretDigestKey(nil)
+
+	c := ContainerConfig{
+		Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest",
+		Secrets: SecretsConfig{Files: []SecretConfig{{Name: "token", Value: "hunter2"}}},
+	}
+
+	if bytes.Contains(structhash.Dump(c, checksumVersion), []byte("hunter2")) {
+		t.Errorf("Plain secret value is part of the checksum data")
+	}
+
+	SetSecretDigestKey([]byte("host-a"))
+	hostA, _ := c.Checksum()
+
+	SetSecretDigestKey([]byte("host-b"))
+	hostB, _ := c.Checksum()
+	if hostA == hostB {
+		t.Errorf("Secret digest is not keyed")
+	}
+
+	c.Secrets.Files[0].Value = "hunter3"
+	if cs, _ := c.Checksum(); cs == hostB {
+		t.Errorf("Changed secret value did not change the checksum")
+	}
+}
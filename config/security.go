@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SecurityPolicy contains host-level restrictions for the security
+// options of containers which can not be overridden by the config
+type SecurityPolicy struct {
+	ForbidPrivileged      bool     `yaml:"forbid_privileged"`
+	ForbidHostUserns      bool     `yaml:"forbid_host_userns"`
+	ForbiddenCapabilities []string `yaml:"forbidden_capabilities"`
+	ForbiddenSecurityOpts []string `yaml:"forbidden_security_opts"`
+	RequireNoNewPrivs     bool     `yaml:"require_no_new_privileges"`
+}
+
+var securityPolicy SecurityPolicy
+
+// LoadSecurityPolicy reads the host-level security policy from the
+// given YAML file. An empty filename disables the policy.
+func LoadSecurityPolicy(filename string) error {
+	if filename == "" {
+		securityPolicy = SecurityPolicy{}
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Unable to read security policy: %s", err)
+	}
+
+	p := SecurityPolicy{}
+	if err := yaml.Unmarshal(body, &p); err != nil {
+		return fmt.Errorf("Unable to parse security policy: %s", err)
+	}
+
+	securityPolicy = p
+	return nil
+}
+
+// CheckSecurityPolicy returns an error if the security options of the
+// container violate the host-level security policy
+func (c ContainerConfig) CheckSecurityPolicy() error {
+	p := securityPolicy
+
+	if p.ForbidPrivileged && c.Privileged {
+		return fmt.Errorf("Privileged containers are forbidden")
+	}
+
+	if p.ForbidHostUserns && c.UsernsMode == "host" {
+		return fmt.Errorf("Userns mode host is forbidden")
+	}
+
+	if len(p.ForbiddenCapabilities) > 0 && c.Privileged {
+		// Privileged containers have all capabilities
+		return fmt.Errorf("Privileged containers are forbidden as capabilities are restricted")
+	}
+
+	for _, forbidden := range p.ForbiddenCapabilities {
+		for _, cap := range c.AddCapabilities {
+			if normalizeCapability(cap) == normalizeCapability(forbidden) || normalizeCapability(cap) == "ALL" {
+				return fmt.Errorf("Capability %s is forbidden", cap)
+			}
+		}
+	}
+
+	for _, forbidden := range p.ForbiddenSecurityOpts {
+		for _, opt := range c.SecurityOpt {
+			if strings.HasPrefix(normalizeSecurityOpt(opt), normalizeSecurityOpt(forbidden)) {
+				return fmt.Errorf("Security option %q is forbidden", opt)
+			}
+		}
+	}
+
+	if p.RequireNoNewPrivs && !c.hasNoNewPrivileges() {
+		return fmt.Errorf("Security option no-new-privileges is required")
+	}
+
+	return nil
+}
+
+// ResolveSecurityOpts returns the security options to pass to the docker
+// daemon. Seccomp profiles given as path are read and inlined as the
+// daemon expects the JSON profile itself.
+func (c ContainerConfig) ResolveSecurityOpts() ([]string, error) {
+	if len(c.SecurityOpt) == 0 {
+		return c.SecurityOpt, nil
+	}
+
+	opts := []string{}
+	for _, opt := range c.SecurityOpt {
+		filename, ok := seccompProfileFile(opt)
+		if !ok {
+			opts = append(opts, opt)
+			continue
+		}
+
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read seccomp profile: %s", err)
+		}
+
+		profile := new(bytes.Buffer)
+		if err := json.Compact(profile, body); err != nil {
+			return nil, fmt.Errorf("Seccomp profile %q is no valid JSON: %s", filename, err)
+		}
+
+		opts = append(opts, "seccomp="+profile.String())
+	}
+
+	return opts, nil
+}
+
+// seccompProfileDigests returns the SHA256 of the seccomp profiles given
+// as path so changes to the profile lead to a new checksum
+func (c ContainerConfig) seccompProfileDigests() (map[string]string, error) {
+	digests := map[string]string{}
+	for _, opt := range c.SecurityOpt {
+		filename, ok := seccompProfileFile(opt)
+		if !ok {
+			continue
+		}
+
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read seccomp profile: %s", err)
+		}
+		digests[filename] = fmt.Sprintf("%x", sha256.Sum256(body))
+	}
+	return digests, nil
+}
+
+// seccompProfileFile extracts the path of the profile from a seccomp
+// option not specifying `unconfined` or an inline JSON profile
+func seccompProfileFile(opt string) (string, bool) {
+	opt = normalizeSecurityOpt(opt)
+	if !strings.HasPrefix(opt, "seccomp=") {
+		return "", false
+	}
+
+	value := strings.TrimSpace(strings.TrimPrefix(opt, "seccomp="))
+	if value == "unconfined" || strings.HasPrefix(value, "{") {
+		return "", false
+	}
+	return value, true
+}
+
+func (c ContainerConfig) hasNoNewPrivileges() bool {
+	for _, opt := range c.SecurityOpt {
+		switch normalizeSecurityOpt(opt) {
+		case "no-new-privileges", "no-new-privileges=true":
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeCapability(cap string) string {
+	return strings.TrimPrefix(strings.ToUpper(cap), "CAP_")
+}
+
+// normalizeSecurityOpt converts the legacy colon separated format
+// (e.g. seccomp:unconfined) into the current one
+func normalizeSecurityOpt(opt string) string {
+	if !strings.Contains(opt, "=") {
+		opt = strings.Replace(opt, ":", "=", 1)
+	}
+	return opt
+}
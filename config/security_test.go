@@ -0,0 +1,100 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecurityOpts(t *testing.T) {
+	f, err := ioutil.TempFile("", "seccomp")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n")
+	f.Close()
+
+	for _, tc := range []struct {
+		opts, expected []string
+		err            bool
+	}{
+		{opts: nil, expected: nil},
+		{opts: []string{"no-new-privileges", "apparmor=my-profile"}, expected: []string{"no-new-privileges", "apparmor=my-profile"}},
+		{opts: []string{"seccomp=unconfined"}, expected: []string{"seccomp=unconfined"}},
+		{opts: []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`}, expected: []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`}},
+		{opts: []string{"seccomp=" + f.Name()}, expected: []string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`}},
+		{opts: []string{"seccomp:" + f.Name()}, expected: []string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`}},
+		{opts: []string{"seccomp=/does/not/exist.json"}, err: true},
+		{opts: []string{"seccomp=/etc/hostname"}, err: true},
+	} {
+		c := ContainerConfig{SecurityOpt: tc.opts}
+		opts, err := c.ResolveSecurityOpts()
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error state: %v", tc.opts, err)
+			continue
+		}
+		if !tc.err && !reflect.DeepEqual(opts, tc.expected) {
+			t.Errorf("%q: got %q, expected %q", tc.opts, opts, tc.expected)
+		}
+	}
+}
+
+func TestSecurityPolicyOnParse(t *testing.T) {
+	defer func() { securityPolicy = SecurityPolicy{} }()
+
+	for _, tc := range []struct {
+		name   string
+		policy SecurityPolicy
+		config string
+		err    string
+	}{
+		{
+			name:   "no policy",
+			config: "web: {hosts: [ALL], image: nginx, privileged: true}",
+		},
+		{
+			name:   "privileged",
+			policy: SecurityPolicy{ForbidPrivileged: true},
+			config: "web: {hosts: [ALL], image: nginx, privileged: true}",
+			err:    "Privileged containers are forbidden",
+		},
+		{
+			name:   "capability",
+			policy: SecurityPolicy{ForbiddenCapabilities: []string{"SYS_ADMIN"}},
+			config: "web: {hosts: [ALL], image: nginx, cap_add: [CAP_SYS_ADMIN]}",
+			err:    "Capability CAP_SYS_ADMIN is forbidden",
+		},
+		{
+			name:   "security option",
+			policy: SecurityPolicy{ForbiddenSecurityOpts: []string{"seccomp=unconfined"}},
+			config: "web: {hosts: [ALL], image: nginx, security_opt: ['seccomp:unconfined']}",
+			err:    `Security option "seccomp:unconfined" is forbidden`,
+		},
+		{
+			name:   "no-new-privileges required",
+			policy: SecurityPolicy{RequireNoNewPrivs: true},
+			config: "web: {hosts: [ALL], image: nginx}",
+			err:    "no-new-privileges is required",
+		},
+		{
+			name:   "no-new-privileges given",
+			policy: SecurityPolicy{RequireNoNewPrivs: true},
+			config: "web: {hosts: [ALL], image: nginx, security_opt: [no-new-privileges]}",
+		},
+	} {
+		securityPolicy = tc.policy
+
+		_, err := ParseConfig([]byte(tc.config))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: Unexpected error: %s", tc.name, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%s: Expected error containing %q", tc.name, tc.err)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%s: Error %q does not contain %q", tc.name, err, tc.err)
+		}
+	}
+}
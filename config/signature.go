@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	minisignAlgLegacy    = "Ed"
+	minisignAlgPrehashed = "ED"
+
+	embeddedSignatureMarker = "# -----BEGIN MINISIGN SIGNATURE-----"
+)
+
+// ErrUnsigned is returned when a signature is required but none was found
+var ErrUnsigned = errors.New("Configuration is not signed")
+
+// SignatureLoader is implemented by sources able to fetch a detached
+// minisign signature stored next to the configuration
+type SignatureLoader interface {
+	LoadSignature() ([]byte, error)
+}
+
+// PublicKey represents a minisign public key
+type PublicKey struct {
+	ID  []byte
+	Key ed25519.PublicKey
+}
+
+// ParsePublicKey reads a public key in minisign format. Both the full
+// key file including the untrusted comment and the bare base64 encoded
+// key are accepted.
+func ParsePublicKey(data []byte) (PublicKey, error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			line = l
+			break
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("Unable to decode public key: %s", err)
+	}
+
+	if len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != minisignAlgLegacy {
+		return PublicKey{}, errors.New("Public key is not a minisign ed25519 key")
+	}
+
+	return PublicKey{ID: raw[2:10], Key: ed25519.PublicKey(raw[10:])}, nil
+}
+
+// SplitEmbeddedSignature separates a signature embedded as YAML
+// comments at the end of the configuration from the signed content
+func SplitEmbeddedSignature(body []byte) ([]byte, []byte, bool) {
+	idx := bytes.Index(body, []byte(embeddedSignatureMarker))
+	if idx < 0 || (idx > 0 && body[idx-1] != '\n') {
+		return body, nil, false
+	}
+
+	sig := []string{}
+	for _, l := range strings.Split(string(body[idx+len(embeddedSignatureMarker):]), "\n") {
+		l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "#"))
+		if l != "" {
+			sig = append(sig, l)
+		}
+	}
+
+	return body[:idx], []byte(strings.Join(sig, "\n")), true
+}
+
+// VerifySignature checks the minisign signature of the body against the
+// given trusted public keys including the trusted comment
+func VerifySignature(body, signature []byte, keys []PublicKey) error {
+	lines := []string{}
+	for _, l := range strings.Split(strings.TrimSpace(string(signature)), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			lines = append(lines, l)
+		}
+	}
+
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "trusted comment: ") {
+		return errors.New("Signature is not in minisign format")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("Signature is not in minisign format")
+	}
+
+	globalSig, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("Trusted comment signature is not in minisign format")
+	}
+
+	message := body
+	switch string(sig[:2]) {
+	case minisignAlgLegacy:
+	case minisignAlgPrehashed:
+		message = blake2b512(body)
+	default:
+		return fmt.Errorf("Signature algorithm %q is not supported", sig[:2])
+	}
+
+	for _, k := range keys {
+		if !bytes.Equal(k.ID, sig[2:10]) {
+			continue
+		}
+
+		if !ed25519.Verify(k.Key, message, sig[10:]) {
+			return errors.New("Signature verification failed")
+		}
+
+		globalMessage := append([]byte{}, sig[10:]...)
+		globalMessage = append(globalMessage, strings.TrimPrefix(lines[1], "trusted comment: ")...)
+		if !ed25519.Verify(k.Key, globalMessage, globalSig) {
+			return errors.New("Trusted comment verification failed")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Signature was made with unknown key %X", reverse(sig[2:10]))
+}
+
+// reverse returns a reversed copy of the key ID as minisign displays
+// key IDs as little endian numbers
+func reverse(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := range in {
+		out[len(in)-1-i] = in[i]
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testKey struct {
+	id   []byte
+	priv ed25519.PrivateKey
+	pub  PublicKey
+}
+
+func newTestKey(t *testing.T) testKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return testKey{id: id, priv: priv, pub: PublicKey{ID: id, Key: pub}}
+}
+
+// encodedPublicKey returns the key in minisign public key file format
+func (k testKey) encodedPublicKey() []byte {
+	raw := append(append([]byte(minisignAlgLegacy), k.id...), k.pub.Key...)
+	return []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+}
+
+// sign creates a minisign signature of the body
+func (k testKey) sign(body []byte, alg, comment string) []byte {
+	message := body
+	if alg == minisignAlgPrehashed {
+		message = blake2b512(body)
+	}
+
+	sig := ed25519.Sign(k.priv, message)
+	raw := append(append([]byte(alg), k.id...), sig...)
+	global := ed25519.Sign(k.priv, append(append([]byte{}, sig...), comment...))
+
+	return []byte(fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestBlake2b512(t *testing.T) {
+	// Test vector from RFC 7693
+	expected := "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+		"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"
+	if sum := hex.EncodeToString(blake2b512([]byte("abc"))); sum != expected {
+		t.Errorf("got %s, expected %s", sum, expected)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key := newTestKey(t)
+	bare := strings.Split(string(key.encodedPublicKey()), "\n")[1]
+
+	for _, tc := range []struct {
+		name  string
+		input string
+		err   bool
+	}{
+		{name: "key file", input: string(key.encodedPublicKey())},
+		{name: "bare key", input: bare},
+		{name: "no base64", input: "not a key", err: true},
+		{name: "short", input: base64.StdEncoding.EncodeToString([]byte("Ed1234")), err: true},
+		{name: "wrong algorithm", input: "AA" + bare[2:], err: true},
+	} {
+		pk, err := ParsePublicKey([]byte(tc.input))
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error state: %v", tc.name, err)
+			continue
+		}
+		if !tc.err && (string(pk.ID) != string(key.id) || !pk.Key.Equal(key.pub.Key)) {
+			t.Errorf("%s: parsed key does not match", tc.name)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	var (
+		key   = newTestKey(t)
+		other = newTestKey(t)
+		body  = []byte("web:\n  image: nginx\n")
+		good  = key.sign(body, minisignAlgPrehashed, "timestamp:1500000000")
+	)
+
+	for _, tc := range []struct {
+		name      string
+		body, sig []byte
+		keys      []PublicKey
+		err       string
+	}{
+		{name: "prehashed", body: body, sig: good, keys: []PublicKey{key.pub}},
+		{name: "legacy", body: body, sig: key.sign(body, minisignAlgLegacy, "c"), keys: []PublicKey{key.pub}},
+		{name: "second key", body: body, sig: good, keys: []PublicKey{other.pub, key.pub}},
+		{name: "modified body", body: append([]byte("#\n"), body...), sig: good, keys: []PublicKey{key.pub}, err: "Signature verification failed"},
+		{name: "unknown key", body: body, sig: good, keys: []PublicKey{other.pub}, err: "unknown key"},
+		{name: "modified comment", body: body, sig: []byte(strings.Replace(string(good), "1500000000", "1600000000", 1)), keys: []PublicKey{key.pub}, err: "Trusted comment verification failed"},
+		{name: "garbage", body: body, sig: []byte("foo"), keys: []PublicKey{key.pub}, err: "not in minisign format"},
+		{name: "unknown algorithm", body: body, sig: key.sign(body, "XX", "c"), keys: []PublicKey{key.pub}, err: "not supported"},
+	} {
+		err := VerifySignature(tc.body, tc.sig, tc.keys)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("%s: expected error containing %q", tc.name, tc.err)
+		case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+			t.Errorf("%s: error %q does not contain %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestSplitEmbeddedSignature(t *testing.T) {
+	key := newTestKey(t)
+	body := []byte("web:\n  image: nginx\n")
+
+	embedded := []byte(string(body) + embeddedSignatureMarker + "\n")
+	for _, l := range strings.Split(strings.TrimSpace(string(key.sign(body, minisignAlgPrehashed, "c"))), "\n") {
+		embedded = append(embedded, "# "+l+"\n"...)
+	}
+
+	content, sig, ok := SplitEmbeddedSignature(embedded)
+	if !ok {
+		t.Fatalf("Embedded signature not found")
+	}
+	if string(content) != string(body) {
+		t.Errorf("Content %q does not match %q", content, body)
+	}
+	if err := VerifySignature(content, sig, []PublicKey{key.pub}); err != nil {
+		t.Errorf("Embedded signature did not verify: %s", err)
+	}
+
+	if _, _, ok := SplitEmbeddedSignature(body); ok {
+		t.Errorf("Found signature in unsigned body")
+	}
+	if _, _, ok := SplitEmbeddedSignature([]byte("a: b " + embeddedSignatureMarker)); ok {
+		t.Errorf("Found signature marker not at line start")
+	}
+}
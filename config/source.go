@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotModified is returned by a Source when the configuration did
+// not change since the last successful load
+var ErrNotModified = errors.New("Configuration was not modified")
+
+// Source represents a location the raw configuration is loaded from
+type Source interface {
+	// Load retrieves the raw configuration. Sources able to detect
+	// unchanged configurations may return ErrNotModified.
+	Load() ([]byte, error)
+	// String returns a human readable description of the source
+	String() string
+}
+
+// Watcher is implemented by sources which are able to notify about
+// changes instead of relying on polling only
+type Watcher interface {
+	// Watch sends a notification into the channel whenever the source
+	// changed. It returns immediately after setting up the watch.
+	Watch(notify chan<- struct{}) error
+}
+
+// Committer is implemented by sources remembering the last loaded
+// version to return ErrNotModified. Commit is called after the loaded
+// configuration was verified and parsed so a refused configuration is
+// loaded again on the next try.
+type Committer interface {
+	Commit()
+}
+
+// SourceOptions contains settings used by the different sources
+type SourceOptions struct {
+	// WorkDir is a directory the source may store data in (git clones)
+	WorkDir string
+
+	GitVerifySignatures bool
+
+	S3Endpoint string
+	S3Region   string
+
+	HTTPTimeout         time.Duration
+	HTTPRetries         int
+	HTTPRetryBackoff    time.Duration
+	HTTPHeaders         []string
+	HTTPBearerTokenFile string
+	HTTPClientCert      string
+	HTTPClientKey       string
+	HTTPCACert          string
+}
+
+// DefaultSourceOptions returns the options used by the command line
+// defaults for callers not configuring the sources themselves
+func DefaultSourceOptions() SourceOptions {
+	return SourceOptions{
+		HTTPTimeout:      30 * time.Second,
+		HTTPRetries:      3,
+		HTTPRetryBackoff: time.Second,
+	}
+}
+
+// signatureSuffix is appended to the location of the config to find
+// the detached signature
+const signatureSuffix = ".minisig"
+
+// NewSource creates the Source matching the given location:
+//
+//   - existing local files and file:// URLs are read from disk
+//   - git+https://, git+ssh:// and git+file:// URLs are cloned
+//   - s3:// URLs are fetched from S3 compatible object storage
+//   - everything else is treated as an HTTP(S) URL
+func NewSource(location string, opts SourceOptions) (Source, error) {
+	if _, err := os.Stat(location); err == nil {
+		return NewFileSource(location), nil
+	}
+
+	switch {
+	case strings.HasPrefix(location, "file://"):
+		return NewFileSource(strings.TrimPrefix(location, "file://")), nil
+
+	case strings.HasPrefix(location, "git+"):
+		return NewGitSource(strings.TrimPrefix(location, "git+"), opts)
+
+	case strings.HasPrefix(location, "s3://"):
+		return NewS3Source(location, opts)
+
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return NewHTTPSource(location, opts)
+	}
+
+	return nil, fmt.Errorf("Config location %q is neither an existing file nor a supported URL", location)
+}
+
+// LoadConfigFromSource retrieves a Config object from the given Source
+func LoadConfigFromSource(src Source) (Config, error) {
+	body, err := src.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ParseConfig(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if committer, ok := src.(Committer); ok {
+		committer.Commit()
+	}
+
+	return c, nil
+}
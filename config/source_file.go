@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// FileSource reads the configuration from a local file
+type FileSource struct {
+	Filename string
+}
+
+// NewFileSource creates a new FileSource for the given file
+func NewFileSource(filename string) *FileSource {
+	return &FileSource{Filename: filename}
+}
+
+// Load implements the Source interface
+func (f *FileSource) Load() ([]byte, error) {
+	body, err := ioutil.ReadFile(f.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config from file %q: %s", f.Filename, err)
+	}
+
+	return body, nil
+}
+
+func (f *FileSource) String() string { return "file://" + f.Filename }
+
+// LoadSignature implements the SignatureLoader interface
+func (f *FileSource) LoadSignature() ([]byte, error) {
+	return ioutil.ReadFile(f.Filename + signatureSuffix)
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Watch implements the Watcher interface using inotify. The directory
+// containing the file is watched as editors and config management tools
+// tend to replace the file instead of writing to it.
+func (f *FileSource) Watch(notify chan<- struct{}) error {
+	filename, err := filepath.Abs(f.Filename)
+	if err != nil {
+		return fmt.Errorf("Unable to determine absolute path: %s", err)
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("Unable to initialize inotify: %s", err)
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, path.Dir(filename),
+		syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE|syscall.IN_DELETE); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Unable to watch %q: %s", path.Dir(filename), err)
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err != nil {
+				log.Errorf("Unable to read inotify events, stopping to watch %q: %s", filename, err)
+				return
+			}
+
+			changed := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				evt := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(evt.Len)]
+				if strings.TrimRight(string(nameBytes), "\x00") == path.Base(filename) {
+					changed = true
+				}
+				offset += syscall.SizeofInotifyEvent + int(evt.Len)
+			}
+
+			if changed {
+				log.Debugf("Config file %q changed", filename)
+				notify <- struct{}{}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package config
+
+import "errors"
+
+// Watch is not supported on non-linux systems, changes are only
+// detected through the regular config reload
+func (f *FileSource) Watch(notify chan<- struct{}) error {
+	return errors.New("Watching files is only supported on linux")
+}
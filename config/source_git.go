@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	defaultGitBranch = "master"
+	defaultGitPath   = "config.yaml"
+)
+
+// GitSource reads the configuration from a file inside a git repository
+// which is cloned into the work dir and updated on every load
+type GitSource struct {
+	Repository       string
+	Branch           string
+	Path             string
+	VerifySignatures bool
+
+	workDir     string
+	lastHead    string
+	pendingHead string
+}
+
+// NewGitSource creates a new GitSource from a location in the format
+// `<repository>[#<branch>[:<path>]]`
+func NewGitSource(location string, opts SourceOptions) (*GitSource, error) {
+	g := &GitSource{
+		Branch:           defaultGitBranch,
+		Path:             defaultGitPath,
+		VerifySignatures: opts.GitVerifySignatures,
+	}
+
+	parts := strings.SplitN(location, "#", 2)
+	g.Repository = parts[0]
+	if len(parts) == 2 {
+		ref := strings.SplitN(parts[1], ":", 2)
+		if ref[0] != "" {
+			g.Branch = ref[0]
+		}
+		if len(ref) == 2 && ref[1] != "" {
+			g.Path = ref[1]
+		}
+	}
+
+	if g.Repository == "" {
+		return nil, fmt.Errorf("Git location %q does not contain a repository", location)
+	}
+
+	if opts.WorkDir == "" {
+		return nil, fmt.Errorf("Git sources require a work dir")
+	}
+	g.workDir = path.Join(opts.WorkDir, "git", fmt.Sprintf("%x", sha1.Sum([]byte(g.Repository+"#"+g.Branch))))
+
+	return g, nil
+}
+
+// Load implements the Source interface
+func (g *GitSource) Load() ([]byte, error) {
+	if _, err := os.Stat(path.Join(g.workDir, ".git")); err != nil {
+		if err := os.MkdirAll(path.Dir(g.workDir), 0700); err != nil {
+			return nil, fmt.Errorf("Unable to create git work dir: %s", err)
+		}
+		if _, err := g.git("", "clone", "--quiet", "--single-branch", "--branch", g.Branch, g.Repository, g.workDir); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := g.git(g.workDir, "fetch", "--quiet", "origin", g.Branch); err != nil {
+			return nil, err
+		}
+	}
+
+	head, err := g.git(g.workDir, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		// Fresh clones do not have a FETCH_HEAD
+		if head, err = g.git(g.workDir, "rev-parse", "HEAD"); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.VerifySignatures {
+		if _, err := g.git(g.workDir, "verify-commit", head); err != nil {
+			return nil, fmt.Errorf("Commit %s does not carry a valid signature: %s", head, err)
+		}
+	}
+
+	if _, err := g.git(g.workDir, "reset", "--quiet", "--hard", head); err != nil {
+		return nil, err
+	}
+
+	if head == g.lastHead {
+		return nil, ErrNotModified
+	}
+
+	body, err := ioutil.ReadFile(path.Join(g.workDir, g.Path))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q from repository: %s", g.Path, err)
+	}
+
+	g.pendingHead = head
+	return body, nil
+}
+
+// Commit implements the Committer interface
+func (g *GitSource) Commit() { g.lastHead = g.pendingHead }
+
+func (g *GitSource) String() string {
+	return fmt.Sprintf("git+%s#%s:%s", g.Repository, g.Branch, g.Path)
+}
+
+func (g *GitSource) git(dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Command 'git %s' failed: %s (%s)", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// LoadSignature implements the SignatureLoader interface
+func (g *GitSource) LoadSignature() ([]byte, error) {
+	return ioutil.ReadFile(path.Join(g.workDir, g.Path+signatureSuffix))
+}
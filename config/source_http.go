@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPSource fetches the configuration from a HTTP(S) URL and uses
+// ETag / Last-Modified headers to detect unchanged configurations
+type HTTPSource struct {
+	URL string
+
+	client  *http.Client
+	headers http.Header
+	opts    SourceOptions
+
+	etag         string
+	lastModified string
+
+	pendingETag         string
+	pendingLastModified string
+}
+
+// NewHTTPSource creates a new HTTPSource for the given URL
+func NewHTTPSource(url string, opts SourceOptions) (*HTTPSource, error) {
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HTTPSource{
+		URL: url,
+
+		client:  client,
+		headers: http.Header{},
+		opts:    opts,
+	}
+
+	for _, hdr := range opts.HTTPHeaders {
+		if hdr == "" {
+			continue
+		}
+
+		parts := strings.SplitN(hdr, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Header %q is not in format 'Name: value'", hdr)
+		}
+		h.headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	return h, nil
+}
+
+// Load implements the Source interface
+func (h *HTTPSource) Load() ([]byte, error) {
+	conditional := http.Header{}
+	if h.etag != "" {
+		conditional.Set("If-None-Match", h.etag)
+	}
+	if h.lastModified != "" {
+		conditional.Set("If-Modified-Since", h.lastModified)
+	}
+
+	body, header, err := h.get(h.URL, conditional)
+	if err != nil {
+		return nil, err
+	}
+
+	h.pendingETag = header.Get("ETag")
+	h.pendingLastModified = header.Get("Last-Modified")
+
+	return body, nil
+}
+
+// Commit implements the Committer interface
+func (h *HTTPSource) Commit() {
+	h.etag, h.lastModified = h.pendingETag, h.pendingLastModified
+}
+
+func (h *HTTPSource) String() string { return h.URL }
+
+// LoadSignature implements the SignatureLoader interface
+func (h *HTTPSource) LoadSignature() ([]byte, error) {
+	body, _, err := h.get(h.URL+signatureSuffix, nil)
+	return body, err
+}
+
+func (h *HTTPSource) get(url string, extraHeaders http.Header) ([]byte, http.Header, error) {
+	resp, err := doHTTPWithRetries(h.client, h.opts, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create request: %s", err)
+		}
+
+		for _, hdrs := range []http.Header{h.headers, extraHeaders} {
+			for k, v := range hdrs {
+				req.Header[k] = v
+			}
+		}
+
+		if h.opts.HTTPBearerTokenFile != "" {
+			// Read the token on every request to support token rotation
+			token, err := ioutil.ReadFile(h.opts.HTTPBearerTokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read bearer token: %s", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+		}
+
+		return req, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil, ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("Fetching %q failed with status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read HTTP body: %s", err)
+	}
+
+	return body, resp.Header, nil
+}
+
+func newHTTPClient(opts SourceOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if opts.HTTPClientCert != "" || opts.HTTPClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.HTTPClientCert, opts.HTTPClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.HTTPCACert != "" {
+		pem, err := ioutil.ReadFile(opts.HTTPCACert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA certificate: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %q", opts.HTTPCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   opts.HTTPTimeout,
+		Transport: transport,
+	}, nil
+}
+
+// doHTTPWithRetries executes the request created by newRequest and
+// retries on network errors and server side errors with an exponential
+// backoff. All other responses are returned to the caller.
+func doHTTPWithRetries(client *http.Client, opts SourceOptions, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var (
+		backoff = opts.HTTPRetryBackoff
+		lastErr error
+	)
+
+	for attempt := 0; attempt <= opts.HTTPRetries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("Request failed (%s), retrying in %s...", lastErr, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("Unable to fetch %q: %s", req.URL, err)
+			continue
+		}
+
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("Fetching %q failed with status %d", req.URL, resp.StatusCode)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSourceCommit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("config"))
+	}))
+	defer srv.Close()
+
+	src, err := NewHTTPSource(srv.URL, SourceOptions{HTTPTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Unable to create source: %s", err)
+	}
+
+	// Without commit the configuration is loaded again
+	for i := 0; i < 2; i++ {
+		if _, err := src.Load(); err != nil {
+			t.Fatalf("Load %d without commit failed: %s", i, err)
+		}
+	}
+
+	src.Commit()
+	if _, err := src.Load(); err != ErrNotModified {
+		t.Errorf("Load after commit: expected ErrNotModified, got %v", err)
+	}
+}
+
+func TestLoadConfigFromURLRetries(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("web:\n  hosts: [ALL]\n  image: nginx\n"))
+	}))
+	defer srv.Close()
+
+	cfg, err := LoadConfigFromURL(srv.URL)
+	if err != nil {
+		t.Fatalf("Server error was not retried: %s", err)
+	}
+	if _, ok := cfg["web"]; !ok || requests != 2 {
+		t.Errorf("Unexpected result after %d requests: %v", requests, cfg)
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Endpoint = "https://s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Source fetches the configuration from a S3 compatible object
+// storage using path-style requests signed with AWS signature v4.
+// Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables.
+type S3Source struct {
+	Bucket   string
+	Key      string
+	Endpoint string
+	Region   string
+
+	client *http.Client
+	opts   SourceOptions
+
+	etag        string
+	pendingETag string
+}
+
+// NewS3Source creates a new S3Source from a location in the format
+// `s3://<bucket>/<key>`
+func NewS3Source(location string, opts SourceOptions) (*S3Source, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse S3 location: %s", err)
+	}
+
+	s := &S3Source{
+		Bucket:   u.Host,
+		Key:      strings.TrimLeft(u.Path, "/"),
+		Endpoint: opts.S3Endpoint,
+		Region:   opts.S3Region,
+
+		opts: opts,
+	}
+
+	if s.Bucket == "" || s.Key == "" {
+		return nil, fmt.Errorf("S3 location %q needs to be in format s3://<bucket>/<key>", location)
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = defaultS3Endpoint
+	}
+	if s.Region == "" {
+		s.Region = defaultS3Region
+	}
+
+	if s.client, err = newHTTPClient(opts); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Load implements the Source interface
+func (s *S3Source) Load() ([]byte, error) {
+	body, etag, err := s.get(s.Key, s.etag)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pendingETag = etag
+	return body, nil
+}
+
+// Commit implements the Committer interface
+func (s *S3Source) Commit() { s.etag = s.pendingETag }
+
+// LoadSignature implements the SignatureLoader interface
+func (s *S3Source) LoadSignature() ([]byte, error) {
+	body, _, err := s.get(s.Key+signatureSuffix, "")
+	return body, err
+}
+
+func (s *S3Source) get(key, etag string) ([]byte, string, error) {
+	endpoint, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to parse S3 endpoint: %s", err)
+	}
+
+	objectURL := *endpoint
+	objectURL.Path = endpoint.Path + "/" + s.Bucket + "/" + key
+
+	resp, err := doHTTPWithRetries(s.client, s.opts, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", objectURL.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create request: %s", err)
+		}
+
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		// Sign every attempt as the signature contains the current time
+		return req, s.sign(req, time.Now().UTC())
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", ErrNotModified
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to read S3 response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("S3 responded with status %d for %q: %s", resp.StatusCode, key, strings.TrimSpace(string(body)))
+	}
+
+	return body, resp.Header.Get("ETag"), nil
+}
+
+func (s *S3Source) String() string { return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Key) }
+
+func (s *S3Source) sign(req *http.Request, now time.Time) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return errors.New("S3 sources require AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	scope := strings.Join([]string{now.Format("20060102"), s.Region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + emptyPayloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if token := req.Header.Get("X-Amz-Security-Token"); token != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+		canonicalHeaders += "x-amz-security-token:" + token + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		emptyPayloadHash,
+	}, "\n")
+
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(crHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{now.Format("20060102"), s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, strings.Join(signedHeaders, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
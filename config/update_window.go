@@ -0,0 +1,368 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// Update policies controlling whether updates are executed automatically
+const (
+	UpdatePolicyAuto   = "auto"
+	UpdatePolicyNotify = "notify"
+	UpdatePolicyManual = "manual"
+)
+
+// Update strategies controlling how a container is replaced on updates
+const (
+	UpdateStrategyStopFirst  = "stop-first"
+	UpdateStrategyStartFirst = "start-first"
+)
+
+// nextWindowSearchLimit limits how far NextUpdateAllowed looks ahead
+const nextWindowSearchLimit = 31 * 24 * time.Hour
+
+var (
+	blackouts []blackout
+
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+)
+
+type blackout struct {
+	start, end time.Time
+}
+
+// updateWindow represents a single entry of the update_times. These
+// formats are supported:
+//
+//	HH:MM-HH:MM                 every day (may cross midnight)
+//	Mon-Fri HH:MM-HH:MM         on the given weekdays (start day counts)
+//	Sat,Sun HH:MM-HH:MM         on the listed weekdays
+//	<cron spec> for <duration>  starting at the cron times
+type updateWindow struct {
+	days       [7]bool
+	start, end time.Duration
+
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// SetBlackouts defines periods in which no updates are allowed for
+// non-critical containers. Periods are given as a single date
+// (`2006-01-02`) or as a range of dates (`2006-01-02/2006-01-06`)
+// including both dates. Dates are interpreted in the default timezone.
+func SetBlackouts(periods []string) error {
+	parsed := []blackout{}
+
+	for _, p := range periods {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		dates := strings.SplitN(p, "/", 2)
+		if len(dates) == 1 {
+			dates = append(dates, dates[0])
+		}
+
+		start, err := time.ParseInLocation("2006-01-02", dates[0], defaultLocation)
+		if err != nil {
+			return fmt.Errorf("Invalid blackout %q: %s", p, err)
+		}
+		end, err := time.ParseInLocation("2006-01-02", dates[1], defaultLocation)
+		if err != nil {
+			return fmt.Errorf("Invalid blackout %q: %s", p, err)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("Blackout %q ends before it starts", p)
+		}
+
+		parsed = append(parsed, blackout{start: start, end: end.AddDate(0, 0, 1)})
+	}
+
+	blackouts = parsed
+	return nil
+}
+
+func inBlackout(pit time.Time) bool {
+	_, ok := blackoutEnd(pit)
+	return ok
+}
+
+// blackoutEnd returns the end of the blackout containing pit
+func blackoutEnd(pit time.Time) (time.Time, bool) {
+	for _, b := range blackouts {
+		if !pit.Before(b.start) && pit.Before(b.end) {
+			return b.end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseUpdateWindow(spec string) (updateWindow, error) {
+	w := updateWindow{}
+
+	if parts := strings.SplitN(spec, " for ", 2); len(parts) == 2 {
+		cronSpec := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(cronSpec, "@") && len(strings.Fields(cronSpec)) < 6 {
+			cronSpec = "0 " + cronSpec
+		}
+
+		var err error
+		if w.schedule, err = cron.Parse(cronSpec); err != nil {
+			return w, fmt.Errorf("Timeframe %q has an invalid cron spec: %s", spec, err)
+		}
+		if w.duration, err = time.ParseDuration(strings.TrimSpace(parts[1])); err != nil || w.duration <= 0 {
+			return w, fmt.Errorf("Timeframe %q has an invalid duration", spec)
+		}
+		return w, nil
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, fmt.Errorf("Timeframe %q is invalid: %s", spec, err)
+		}
+		fields = fields[1:]
+
+	default:
+		return w, fmt.Errorf("Timeframe %q is invalid. Format is [days] HH:MM-HH:MM or <cron> for <duration>", spec)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("Timeframe %q is invalid. Format is HH:MM-HH:MM", spec)
+	}
+
+	t1, et1 := time.Parse("15:04", times[0])
+	t2, et2 := time.Parse("15:04", times[1])
+	if et1 != nil || et2 != nil {
+		return w, fmt.Errorf("Timeframe %q is invalid. Format is HH:MM-HH:MM", spec)
+	}
+
+	w.start = time.Duration(t1.Hour())*time.Hour + time.Duration(t1.Minute())*time.Minute
+	w.end = time.Duration(t2.Hour())*time.Hour + time.Duration(t2.Minute())*time.Minute
+	if w.end <= w.start {
+		// Window crosses midnight
+		w.end += 24 * time.Hour
+	}
+
+	return w, nil
+}
+
+func (w *updateWindow) parseDays(spec string) error {
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return fmt.Errorf("Unknown weekday %q", bounds[0])
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return fmt.Errorf("Unknown weekday %q", bounds[1])
+			}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w updateWindow) contains(pit time.Time) bool {
+	if w.schedule != nil {
+		start := w.schedule.Next(pit.Add(-w.duration))
+		return !start.After(pit)
+	}
+
+	// Check the window starting today and the one started yesterday
+	// as the latter one might cross midnight
+	for _, offset := range []int{0, -1} {
+		day := time.Date(pit.Year(), pit.Month(), pit.Day()+offset, 0, 0, 0, 0, pit.Location())
+		if !w.days[day.Weekday()] {
+			continue
+		}
+
+		if !pit.Before(day.Add(w.start)) && pit.Before(day.Add(w.end)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextStart returns the first point in time not before pit the window
+// is open at. A zero time is returned if the window never opens.
+func (w updateWindow) nextStart(pit time.Time) time.Time {
+	if w.contains(pit) {
+		return pit
+	}
+
+	if w.schedule != nil {
+		return w.schedule.Next(pit)
+	}
+
+	// The window of the previous day might start after midnight on days
+	// switching to daylight saving time
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(pit.Year(), pit.Month(), pit.Day()+offset, 0, 0, 0, 0, pit.Location())
+		if start := day.Add(w.start); w.days[day.Weekday()] && start.After(pit) {
+			return start
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c ContainerConfig) validateUpdatePolicy() error {
+	switch c.UpdatePolicy {
+	case "", UpdatePolicyAuto, UpdatePolicyNotify, UpdatePolicyManual:
+		return nil
+	}
+	return fmt.Errorf("Unknown policy %q", c.UpdatePolicy)
+}
+
+func (c ContainerConfig) validateUpdateStrategy() error {
+	switch c.UpdateStrategy {
+	case "", UpdateStrategyStopFirst:
+		return nil
+	case UpdateStrategyStartFirst:
+	default:
+		return fmt.Errorf("Unknown strategy %q", c.UpdateStrategy)
+	}
+
+	if c.StartTimes != "" {
+		return errors.New("Strategy start-first is not supported for scheduled containers")
+	}
+
+	for _, p := range c.Ports {
+		bindings, err := p.Bindings()
+		if err != nil {
+			return err
+		}
+
+		for _, b := range bindings {
+			// Two containers cannot bind the same host port at the same time
+			if b.HostPort != "" {
+				return fmt.Errorf("Strategy start-first is not possible with host port binding %q", p.Local)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *ContainerConfig) validateUpdateTimes() error {
+	windows, err := parseUpdateWindows(c.UpdateTimes)
+	if err != nil {
+		return err
+	}
+
+	c.windows = windows
+	return nil
+}
+
+func parseUpdateWindows(specs []string) ([]updateWindow, error) {
+	windows := []updateWindow{}
+	for _, timeFrame := range specs {
+		w, err := parseUpdateWindow(timeFrame)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// updateWindows returns the update_times parsed when loading the
+// config. Configs not loaded through ParseConfig are parsed on demand.
+func (c ContainerConfig) updateWindows() ([]updateWindow, error) {
+	if c.windows != nil {
+		return c.windows, nil
+	}
+	return parseUpdateWindows(c.UpdateTimes)
+}
+
+// UpdateAllowedAt checks whether a container may be updated at the given time
+func (c ContainerConfig) UpdateAllowedAt(pit time.Time) (bool, error) {
+	if !c.Critical && inBlackout(pit) {
+		return false, nil
+	}
+
+	windows, err := c.updateWindows()
+	if err != nil {
+		return false, err
+	}
+
+	if len(windows) == 0 {
+		return true, nil
+	}
+
+	local := pit.In(c.location())
+	for _, w := range windows {
+		if w.contains(local) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NextUpdateAllowed searches the next point in time after pit (at
+// minute granularity) the container may be updated at. If none is found
+// within the next 31 days false is returned.
+func (c ContainerConfig) NextUpdateAllowed(pit time.Time) (time.Time, bool) {
+	windows, err := c.updateWindows()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	limit := pit.Add(nextWindowSearchLimit)
+	t := pit.Truncate(time.Minute).Add(time.Minute).In(c.location())
+
+	for t.Before(limit) {
+		next := t
+		if len(windows) > 0 {
+			// Earliest opening of any of the windows
+			next = time.Time{}
+			for _, w := range windows {
+				if start := w.nextStart(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+					next = start
+				}
+			}
+
+			if next.IsZero() || !next.Before(limit) {
+				return time.Time{}, false
+			}
+		}
+
+		end, blocked := blackoutEnd(next)
+		if c.Critical || !blocked {
+			return next.In(pit.Location()), true
+		}
+
+		// Continue after the blackout, a window might still be open
+		t = end.In(t.Location())
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUpdateWindow(t *testing.T) {
+	for _, spec := range []string{
+		"02:00-04:00",
+		"22:00-02:00",
+		"Mon-Fri 02:00-04:00",
+		"sat,sun 10:00-12:00",
+		"Fri-Mon 02:00-04:00",
+		"0 3 * * 1 for 2h",
+		"@daily for 30m",
+	} {
+		if _, err := parseUpdateWindow(spec); err != nil {
+			t.Errorf("%q: unexpected error: %s", spec, err)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"02:00",
+		"2am-4am",
+		"Mon-Fri 02:00-04:00 UTC",
+		"Moo 02:00-04:00",
+		"0 3 * * 1 for soon",
+		"0 3 * * 1 for -1h",
+		"0 3 * * 99 for 1h",
+	} {
+		if _, err := parseUpdateWindow(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestUpdateAllowedAt(t *testing.T) {
+	// 2017-06-05 is a Monday
+	at := func(day, hour, min int) time.Time { return time.Date(2017, 6, day, hour, min, 0, 0, time.UTC) }
+
+	for _, tc := range []struct {
+		window  string
+		pit     time.Time
+		allowed bool
+	}{
+		{"02:00-04:00", at(5, 3, 0), true},
+		{"02:00-04:00", at(5, 2, 0), true},
+		{"02:00-04:00", at(5, 4, 0), false},
+		{"02:00-04:00", at(5, 1, 59), false},
+		{"22:00-02:00", at(5, 23, 0), true},
+		{"22:00-02:00", at(6, 1, 0), true},
+		{"22:00-02:00", at(5, 12, 0), false},
+		{"Mon-Fri 02:00-04:00", at(5, 3, 0), true},
+		{"Mon-Fri 02:00-04:00", at(4, 3, 0), false},
+		{"Sat,Sun 10:00-12:00", at(4, 11, 0), true},
+		{"Sat,Sun 10:00-12:00", at(5, 11, 0), false},
+		// Window of the start day continues past midnight
+		{"Sun 23:00-01:00", at(5, 0, 30), true},
+		{"Mon 23:00-01:00", at(5, 0, 30), false},
+		{"0 3 * * 1 for 2h", at(5, 4, 0), true},
+		{"0 3 * * 1 for 2h", at(5, 5, 0), false},
+		{"0 3 * * 1 for 2h", at(6, 4, 0), false},
+	} {
+		c := ContainerConfig{UpdateTimes: []string{tc.window}, Timezone: "UTC"}
+		allowed, err := c.UpdateAllowedAt(tc.pit)
+		if err != nil {
+			t.Errorf("%q at %s: unexpected error: %s", tc.window, tc.pit, err)
+			continue
+		}
+		if allowed != tc.allowed {
+			t.Errorf("%q at %s: got %v, expected %v", tc.window, tc.pit, allowed, tc.allowed)
+		}
+	}
+}
+
+func TestNextUpdateAllowed(t *testing.T) {
+	// 2017-06-05 is a Monday
+	at := func(day, hour, min int) time.Time { return time.Date(2017, 6, day, hour, min, 0, 0, time.UTC) }
+
+	for _, tc := range []struct {
+		windows  []string
+		pit      time.Time
+		expected time.Time
+		found    bool
+	}{
+		{[]string{"02:00-04:00"}, at(5, 12, 0), at(6, 2, 0), true},
+		{[]string{"02:00-04:00"}, at(5, 1, 0), at(5, 2, 0), true},
+		{[]string{"22:00-02:00"}, at(5, 12, 0), at(5, 22, 0), true},
+		{[]string{"Sat,Sun 10:00-12:00"}, at(5, 12, 0), at(10, 10, 0), true},
+		{[]string{"Sat 10:00-12:00", "Wed 02:00-03:00"}, at(5, 12, 0), at(7, 2, 0), true},
+		{[]string{"0 3 * * 1 for 2h"}, at(5, 12, 0), at(12, 3, 0), true},
+		{[]string{"0 3 1 1 * for 1h"}, at(5, 12, 0), time.Time{}, false},
+		// Inside a window the next full minute is returned
+		{[]string{"22:00-02:00"}, at(5, 23, 30).Add(10 * time.Second), at(5, 23, 31), true},
+		{nil, at(5, 12, 0), at(5, 12, 1), true},
+	} {
+		c := ContainerConfig{UpdateTimes: tc.windows, Timezone: "UTC"}
+		next, found := c.NextUpdateAllowed(tc.pit)
+		if found != tc.found || !next.Equal(tc.expected) {
+			t.Errorf("%v after %s: got %s (%v), expected %s (%v)", tc.windows, tc.pit, next, found, tc.expected, tc.found)
+		}
+	}
+}
+
+func TestNextUpdateAllowedBlackout(t *testing.T) {
+	defer SetBlackouts(nil)
+	defer func(loc *time.Location) { defaultLocation = loc }(defaultLocation)
+	defaultLocation = time.UTC
+
+	// 2017-06-05 is a Monday
+	at := func(day, hour, min int) time.Time { return time.Date(2017, 6, day, hour, min, 0, 0, time.UTC) }
+
+	if err := SetBlackouts([]string{"2017-06-06/2017-06-07"}); err != nil {
+		t.Fatalf("Unable to set blackouts: %s", err)
+	}
+
+	for _, tc := range []struct {
+		windows  []string
+		critical bool
+		expected time.Time
+	}{
+		{nil, false, at(8, 0, 0)},
+		{nil, true, at(6, 12, 1)},
+		{[]string{"02:00-04:00"}, false, at(8, 2, 0)},
+		{[]string{"02:00-04:00"}, true, at(7, 2, 0)},
+		// Window opened during the blackout is still open at its end
+		{[]string{"22:00-02:00"}, false, at(8, 0, 0)},
+	} {
+		c := ContainerConfig{UpdateTimes: tc.windows, Critical: tc.critical}
+		if next, found := c.NextUpdateAllowed(at(6, 12, 0)); !found || !next.Equal(tc.expected) {
+			t.Errorf("%v (critical %v): got %s (%v), expected %s", tc.windows, tc.critical, next, found, tc.expected)
+		}
+	}
+}
+
+// The calculated next window needs to match the first minute
+// UpdateAllowedAt reports as allowed
+func TestNextUpdateAllowedMatchesScan(t *testing.T) {
+	windows := [][]string{
+		{"02:00-04:00"},
+		{"22:00-02:00"},
+		{"Fri-Mon 23:30-00:15"},
+		{"Sat,Sun 10:00-12:00", "Wed 02:00-03:00"},
+		{"0 */6 * * * for 30m"},
+		{"0 3 * * 1 for 2h", "Thu 12:00-12:01"},
+	}
+
+	for _, tz := range []string{"UTC", "Europe/Berlin", "America/New_York"} {
+		for _, w := range windows {
+			c := ContainerConfig{UpdateTimes: w, Timezone: tz}
+			if err := c.validateTiming(); err != nil {
+				t.Fatalf("%s: %s", tz, err)
+			}
+			if err := c.validateUpdateTimes(); err != nil {
+				t.Fatalf("%v: %s", w, err)
+			}
+			// Covers the DST switch in both timezones
+			for pit := time.Date(2017, 3, 8, 0, 7, 0, 0, time.UTC); pit.Before(time.Date(2017, 3, 30, 0, 0, 0, 0, time.UTC)); pit = pit.Add(317 * time.Minute) {
+				var expected time.Time
+				for t := pit.Truncate(time.Minute).Add(time.Minute); ; t = t.Add(time.Minute) {
+					if ok, _ := c.UpdateAllowedAt(t); ok {
+						expected = t
+						break
+					}
+				}
+
+				if next, found := c.NextUpdateAllowed(pit); !found || !next.Equal(expected) {
+					t.Fatalf("%v in %s after %s: got %s (%v), expected %s", w, tz, pit, next, found, expected)
+				}
+			}
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Luzifer/dockermanager/config"
@@ -13,19 +15,66 @@ const (
 	labelIsManaged   = "io.luzifer.dockermanager.managed"
 	labelConfigHash  = "io.luzifer.dockermanager.cfghash"
 	labelIsScheduled = "io.luzifer.dockermanager.scheduler"
+	labelConfigName  = "io.luzifer.dockermanager.cfgname"
+	labelAttempt     = "io.luzifer.dockermanager.attempt"
+	labelIsHook      = "io.luzifer.dockermanager.hook"
+	labelIsInit      = "io.luzifer.dockermanager.init"
+	labelReplica     = "io.luzifer.dockermanager.replica"
 
 	strTrue = "true"
 )
 
-func bootContainer(name string, ccfg *config.ContainerConfig) error {
-	var (
-		container *docker.Container
-		err       error
-	)
+func bootContainer(cfgName, name string, index int, ccfg *config.ContainerConfig) (*docker.Container, error) {
+	if ccfg.Privileged {
+		log.Warnf("Container %q is started PRIVILEGED and has full access to the host", name)
+	}
+
+	opts, err := containerOptions(cfgName, name, index, ccfg)
+	if err != nil {
+		return nil, err
+	}
+
+	secretsBind, err := materializeSecrets(name, ccfg.Secrets)
+	if err != nil {
+		removeSecretsDir(name)
+		return nil, fmt.Errorf("Unable to write secrets: %s", err)
+	}
+	if secretsBind != "" {
+		opts.HostConfig.Binds = append(opts.HostConfig.Binds, secretsBind)
+	}
+
+	log.Debugf("Creating container %s", name)
+	container, err := dockerClient.CreateContainer(opts)
+	if err != nil {
+		removeSecretsDir(name)
+		return nil, fmt.Errorf("Unable to create container: %s", err)
+	}
+
+	log.Infof("Starting container %q...", container.Name)
+	if err := dockerClient.StartContainer(container.Name, nil); err != nil {
+		// Do not leave the container behind, start-first updates would
+		// keep it until the cleanup of stopped containers
+		if rmErr := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    container.ID,
+			Force: true,
+		}); rmErr != nil {
+			log.Errorf("Unable to remove container %q which failed to start: %s", name, rmErr)
+		}
+		removeSecretsDir(name)
+		return nil, fmt.Errorf("Unable to start created container: %s", err)
+	}
+
+	return container, nil
+}
+
+// containerOptions renders the options to create the container from.
+// Secrets are not included as they need to be written to disk.
+func containerOptions(cfgName, name string, index int, ccfg *config.ContainerConfig) (docker.CreateContainerOptions, error) {
+	opts := docker.CreateContainerOptions{Name: name}
 
 	cs, err := ccfg.Checksum()
 	if err != nil {
-		return fmt.Errorf("Unable to calculate checksum: %s", err)
+		return opts, fmt.Errorf("Unable to calculate checksum: %s", err)
 	}
 
 	labels := map[string]string{}
@@ -36,9 +85,50 @@ func bootContainer(name string, ccfg *config.ContainerConfig) error {
 	}
 	labels[labelConfigHash] = cs
 	labels[labelIsManaged] = strTrue
+	labels[labelConfigName] = cfgName
 
 	if ccfg.StartTimes != "" {
 		labels[labelIsScheduled] = strTrue
+		labels[labelAttempt] = strconv.Itoa(ccfg.Attempt())
+	}
+
+	if index > 0 {
+		labels[labelReplica] = strconv.Itoa(index)
+	}
+
+	env, err := ccfg.ReplicaEnvironment(cfgName, index)
+	if err != nil {
+		return opts, err
+	}
+
+	ports, err := ccfg.ReplicaPorts(cfgName, index)
+	if err != nil {
+		return opts, err
+	}
+
+	shmSize, err := ccfg.ShmSizeBytes()
+	if err != nil {
+		return opts, err
+	}
+
+	devices, err := parseDevices(ccfg)
+	if err != nil {
+		return opts, err
+	}
+
+	extraHosts, err := ccfg.RenderExtraHosts(cfgName, index)
+	if err != nil {
+		return opts, err
+	}
+
+	healthInterval, healthTimeout, err := ccfg.Healthcheck.Durations()
+	if err != nil {
+		return opts, err
+	}
+
+	securityOpts, err := ccfg.ResolveSecurityOpts()
+	if err != nil {
+		return opts, err
 	}
 
 	volumes, binds := parseMounts(ccfg.Volumes)
@@ -48,45 +138,80 @@ func bootContainer(name string, ccfg *config.ContainerConfig) error {
 		AttachStdout: true,
 		AttachStderr: true,
 		Image:        strings.Join([]string{ccfg.Image, ccfg.Tag}, ":"),
-		Env:          ccfg.Environment,
+		Env:          env,
 		Cmd:          ccfg.Command,
 		Labels:       labels,
 		Volumes:      volumes,
+		User:         ccfg.User,
+		Entrypoint:   ccfg.Entrypoint,
+		WorkingDir:   ccfg.WorkingDir,
+		Hostname:     ccfg.Hostname,
+		Domainname:   ccfg.Domainname,
+		StopSignal:   ccfg.StopSignal,
+		Tty:          ccfg.Tty,
+		ExposedPorts: make(map[docker.Port]struct{}),
+	}
+
+	if len(ccfg.Healthcheck.Test) > 0 || healthInterval > 0 || healthTimeout > 0 || ccfg.Healthcheck.Retries > 0 {
+		newcfg.Healthcheck = &docker.HealthConfig{
+			Test:     ccfg.Healthcheck.Test,
+			Interval: healthInterval,
+			Timeout:  healthTimeout,
+			Retries:  ccfg.Healthcheck.Retries,
+		}
 	}
 
 	hostConfig := &docker.HostConfig{
-		Binds:        binds,
-		Links:        ccfg.Links,
-		Privileged:   false,
-		PortBindings: make(map[docker.Port][]docker.PortBinding),
-		CapAdd:       ccfg.AddCapabilities,
+		Binds:          binds,
+		Links:          ccfg.Links,
+		Privileged:     ccfg.Privileged,
+		PortBindings:   make(map[docker.Port][]docker.PortBinding),
+		CapAdd:         ccfg.AddCapabilities,
+		CapDrop:        ccfg.DropCapabilities,
+		ReadonlyRootfs: ccfg.ReadOnly,
+		Init:           ccfg.Init,
+		GroupAdd:       ccfg.GroupAdd,
+		SecurityOpt:    securityOpts,
+		UsernsMode:     ccfg.UsernsMode,
+		ShmSize:        shmSize,
+		Ulimits:        parseUlimits(ccfg.Ulimits),
+		Sysctls:        ccfg.Sysctls,
+		Devices:        devices,
+		PidMode:        ccfg.PidMode,
+		IpcMode:        ccfg.IpcMode,
+		DNS:            ccfg.DNS,
+		DNSSearch:      ccfg.DNSSearch,
+		DNSOptions:     ccfg.DNSOptions,
+		ExtraHosts:     extraHosts,
 	}
 
-	for _, v := range ccfg.Ports {
-		s := strings.Split(v.Local, ":")
-		hostConfig.PortBindings[docker.Port(v.Container)] = []docker.PortBinding{{
-			HostIP:   s[0],
-			HostPort: s[1],
-		}}
+	if l := ccfg.EffectiveLogging(); l.Driver != "" {
+		hostConfig.LogConfig = docker.LogConfig{
+			Type:   l.Driver,
+			Config: l.Options,
+		}
 	}
 
-	log.Debugf("Creating container %s", name)
-	container, err = dockerClient.CreateContainer(docker.CreateContainerOptions{
-		Name:       name,
-		Config:     newcfg,
-		HostConfig: hostConfig,
-	})
+	for _, v := range ports {
+		bindings, err := v.Bindings()
+		if err != nil {
+			return opts, err
+		}
 
-	if err != nil {
-		return fmt.Errorf("Unable to create container: %s", err)
+		for _, b := range bindings {
+			port := docker.Port(b.ContainerPort)
+			newcfg.ExposedPorts[port] = struct{}{}
+			hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], docker.PortBinding{
+				HostIP:   b.HostIP,
+				HostPort: b.HostPort,
+			})
+		}
 	}
 
-	log.Infof("Starting container %q...", container.Name)
-	if err := dockerClient.StartContainer(container.Name, nil); err != nil {
-		return fmt.Errorf("Unable to start created container: %s", err)
-	}
+	opts.Config = newcfg
+	opts.HostConfig = hostConfig
 
-	return nil
+	return opts, nil
 }
 
 func parseMounts(mountIn []string) (volumes map[string]struct{}, binds []string) {
@@ -108,3 +233,40 @@ func parseMounts(mountIn []string) (volumes map[string]struct{}, binds []string)
 
 	return
 }
+
+func parseDevices(ccfg *config.ContainerConfig) ([]docker.Device, error) {
+	mappings, err := ccfg.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []docker.Device{}
+	for _, d := range mappings {
+		devices = append(devices, docker.Device{
+			PathOnHost:        d.PathOnHost,
+			PathInContainer:   d.PathInContainer,
+			CgroupPermissions: d.CgroupPermissions,
+		})
+	}
+
+	return devices, nil
+}
+
+func parseUlimits(in map[string]config.UlimitConfig) []docker.ULimit {
+	names := []string{}
+	for name := range in {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ulimits := []docker.ULimit{}
+	for _, name := range names {
+		ulimits = append(ulimits, docker.ULimit{
+			Name: name,
+			Soft: in[name].Soft,
+			Hard: in[name].Hard,
+		})
+	}
+
+	return ulimits
+}
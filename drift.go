@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// driftRecord describes a managed container whose settings differ from
+// the ones it would be created with
+type driftRecord struct {
+	Container   string    `json:"container"`
+	ConfigName  string    `json:"config_name"`
+	Differences []string  `json:"differences"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// driftRecords contains the drifted containers by their ID
+type driftRecords map[string]driftRecord
+
+func loadDriftRecords(filename string) (driftRecords, error) {
+	records := driftRecords{}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read drift state: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("Unable to parse drift state: %s", err)
+	}
+
+	return records, nil
+}
+
+func (d driftRecords) save(filename string) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal drift state: %s", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Unable to create state dir: %s", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write drift state: %s", err)
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// EnableDriftDetection periodically compares the managed containers to
+// the settings they would be created with. The drifted containers are
+// stored in the given file, with enforce they are recreated.
+func (s *scheduler) EnableDriftDetection(filename string, interval time.Duration, enforce bool) {
+	s.lock(lockDrift, true)
+	s.drift = driftRecords{}
+	s.driftFile = filename
+	s.driftEnforce = enforce
+	s.unlock(lockDrift, true)
+
+	go func() {
+		for range time.Tick(interval) {
+			s.detectDrift()
+		}
+	}()
+}
+
+func (s *scheduler) detectDrift() {
+	type candidate struct {
+		id, name string
+		cont     container
+	}
+
+	candidates := []candidate{}
+	s.lock(lockContainers, false)
+	for id, cont := range s.knownContainers {
+		if !cont.Container.State.Running || !cont.IsManaged || cont.IsScheduled {
+			continue
+		}
+		if cont.Container.Config.Labels[labelIsHook] == strTrue || cont.Container.Config.Labels[labelIsInit] == strTrue {
+			continue
+		}
+		candidates = append(candidates, candidate{id, strings.TrimLeft(cont.Container.Name, "/"), cont})
+	}
+	s.unlock(lockContainers, false)
+
+	records := driftRecords{}
+	for _, c := range candidates {
+		diffs, err := s.containerDrift(c.id, c.name, c.cont)
+		if err != nil {
+			log.Errorf("Unable to check container %q for drift: %s", c.name, err)
+			continue
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+
+		records[c.id] = driftRecord{
+			Container:   c.name,
+			ConfigName:  c.cont.ConfigName,
+			Differences: diffs,
+			DetectedAt:  time.Now(),
+		}
+	}
+
+	s.lock(lockDrift, true)
+	defer s.unlock(lockDrift, true)
+
+	for id, r := range records {
+		old, ok := s.drift[id]
+		switch {
+		case !ok:
+			log.WithFields(log.Fields{
+				"container": r.Container,
+			}).Warnf("Container drifted from its configuration: %s", strings.Join(r.Differences, "; "))
+
+		case strings.Join(old.Differences, "; ") != strings.Join(r.Differences, "; "):
+			log.WithFields(log.Fields{
+				"container": r.Container,
+			}).Warnf("Container drift changed: %s", strings.Join(r.Differences, "; "))
+			r.DetectedAt = old.DetectedAt
+
+		default:
+			r.DetectedAt = old.DetectedAt
+		}
+		records[id] = r
+	}
+
+	for id, r := range s.drift {
+		if _, ok := records[id]; !ok {
+			log.WithFields(log.Fields{
+				"container": r.Container,
+			}).Infof("Container no longer drifts from its configuration")
+		}
+	}
+
+	s.drift = records
+	if err := s.drift.save(s.driftFile); err != nil {
+		log.Errorf("Unable to save drift state: %s", err)
+	}
+}
+
+// containerDrift inspects the container and compares it to the
+// settings it would be created with. Containers waiting for an update
+// or being replaced are not checked.
+func (s *scheduler) containerDrift(id, name string, cont container) ([]string, error) {
+	s.lock(lockConfig, false)
+	ccfg := s.config[cont.ConfigName]
+	isInstance := s.config.IsInstance(cont.ConfigName, name)
+	s.unlock(lockConfig, false)
+
+	if !isInstance || s.isReplacing(name) {
+		return nil, nil
+	}
+
+	if cs, err := ccfg.Checksum(); err != nil || cs != cont.Checksum {
+		// Configuration changed, container is updated anyway
+		return nil, nil
+	}
+
+	fresh, err := s.client.InspectContainer(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to inspect container: %s", err)
+	}
+
+	want, err := containerOptions(cont.ConfigName, name, cont.Replica, ccfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock(lockImages, false)
+	img := s.knownImages[fresh.Image].Image
+	s.unlock(lockImages, false)
+
+	return diffContainer(fresh, img, want), nil
+}
+
+// driftEnforced checks whether the container drifted and needs to be
+// recreated
+func (s *scheduler) driftEnforced(id string) bool {
+	s.lock(lockDrift, false)
+	defer s.unlock(lockDrift, false)
+
+	_, ok := s.drift[id]
+	return ok && s.driftEnforce
+}
+
+// printDrift implements the `drift` command
+func printDrift(filename string) error {
+	records, err := loadDriftRecords(filename)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{}
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return records[ids[i]].Container < records[ids[j]].Container })
+
+	fmt.Printf("%-25s %-20s %s\n", "CONTAINER", "DETECTED", "DIFFERENCES")
+	for _, id := range ids {
+		r := records[id]
+		for i, d := range r.Differences {
+			if i == 0 {
+				fmt.Printf("%-25s %-20s %s\n", r.Container, r.DetectedAt.Local().Format("2006-01-02 15:04:05"), d)
+				continue
+			}
+			fmt.Printf("%-25s %-20s %s\n", "", "", d)
+		}
+	}
+
+	return nil
+}
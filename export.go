@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// exportContainers implements the `export` command: The running
+// containers (optionally filtered by the labels given as `key` or
+// `key=value`) are printed as configuration for the current host
+func exportContainers(args []string) error {
+	client, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("Unable to create Docker client: %s", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Unable to determine hostname: %s", err)
+	}
+
+	opts := docker.ListContainersOptions{}
+	if len(args) > 0 {
+		opts.Filters = map[string][]string{"label": args}
+	}
+
+	conts, err := client.ListContainers(opts)
+	if err != nil {
+		return fmt.Errorf("Unable to list containers: %s", err)
+	}
+
+	result := config.Config{}
+	for _, c := range conts {
+		cont, err := client.InspectContainer(c.ID)
+		if err != nil {
+			return fmt.Errorf("Unable to inspect container %q: %s", c.ID, err)
+		}
+
+		name := strings.TrimPrefix(cont.Name, "/")
+		if cont.Config.Labels[labelIsHook] == strTrue || cont.Config.Labels[labelIsInit] == strTrue {
+			continue
+		}
+
+		img, err := client.InspectImage(cont.Image)
+		if err != nil {
+			return fmt.Errorf("Unable to inspect image of container %q: %s", name, err)
+		}
+
+		ccfg, err := exportContainer(cont, img, host)
+		if err != nil {
+			log.Warnf("Skipping container %q: %s", name, err)
+			continue
+		}
+
+		result[name] = ccfg
+	}
+
+	out, err := yaml.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal config: %s", err)
+	}
+
+	if _, err := config.ParseConfig(out); err != nil {
+		log.Warnf("Exported config needs manual changes before it can be used: %s", err)
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// exportContainer translates the inspected container into a config.
+// Settings inherited from the image are left out.
+func exportContainer(cont *docker.Container, img *docker.Image, host string) (*config.ContainerConfig, error) {
+	if strings.HasPrefix(cont.Config.Image, "sha256:") {
+		return nil, fmt.Errorf("Container was started from image ID %q without name", cont.Config.Image)
+	}
+
+	image, tag, err := config.SplitImageTag(cont.Config.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	ccfg := &config.ContainerConfig{
+		Hosts:            []string{host},
+		Image:            image,
+		Tag:              tag,
+		Environment:      subtractStrings(cont.Config.Env, img.Config.Env),
+		Volumes:          cont.HostConfig.Binds,
+		AddCapabilities:  cont.HostConfig.CapAdd,
+		DropCapabilities: cont.HostConfig.CapDrop,
+		Privileged:       cont.HostConfig.Privileged,
+		Init:             cont.HostConfig.Init,
+	}
+
+	if !equalStrings(cont.Config.Cmd, img.Config.Cmd) {
+		ccfg.Command = cont.Config.Cmd
+	}
+
+	if !equalStrings(cont.Config.Entrypoint, img.Config.Entrypoint) {
+		ccfg.Entrypoint = cont.Config.Entrypoint
+	}
+
+	for k, v := range cont.Config.Labels {
+		if strings.HasPrefix(k, "io.luzifer.dockermanager.") {
+			continue
+		}
+		if iv, ok := img.Config.Labels[k]; ok && iv == v {
+			continue
+		}
+		if ccfg.Labels == nil {
+			ccfg.Labels = map[string]string{}
+		}
+		ccfg.Labels[k] = v
+	}
+
+	for _, l := range cont.HostConfig.Links {
+		// Links are reported as `/<other>:/<name>/<alias>`
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ccfg.Links = append(ccfg.Links, strings.TrimPrefix(parts[0], "/")+":"+path.Base(parts[1]))
+	}
+	sort.Strings(ccfg.Links)
+
+	ports := []string{}
+	for p := range cont.HostConfig.PortBindings {
+		ports = append(ports, string(p))
+	}
+	sort.Strings(ports)
+
+	for _, p := range ports {
+		for _, b := range cont.HostConfig.PortBindings[docker.Port(p)] {
+			ccfg.Ports = append(ccfg.Ports, config.PortConfig{
+				Container: p,
+				Local:     exportLocalPort(b),
+			})
+		}
+	}
+
+	return ccfg, nil
+}
+
+// exportLocalPort keeps the host IP as reported by Docker as an explicit
+// `0.0.0.0` differs from an unset IP when comparing the containers
+func exportLocalPort(b docker.PortBinding) string {
+	hostIP := b.HostIP
+	if strings.Contains(hostIP, ":") {
+		hostIP = "[" + hostIP + "]"
+	}
+
+	switch {
+	case hostIP == "":
+		return b.HostPort
+	case b.HostPort == "":
+		return hostIP
+	default:
+		return hostIP + ":" + b.HostPort
+	}
+}
+
+// subtractStrings returns the elements of a not contained in b
+func subtractStrings(a, b []string) []string {
+	known := map[string]bool{}
+	for _, s := range b {
+		known[s] = true
+	}
+
+	var out []string
+	for _, s := range a {
+		if !known[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
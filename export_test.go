@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestExportLocalPortRoundTrip(t *testing.T) {
+	for _, b := range []docker.PortBinding{
+		{HostPort: "8080"},
+		{HostIP: "0.0.0.0", HostPort: "8080"},
+		{HostIP: "::", HostPort: "8080"},
+		{HostIP: "127.0.0.1"},
+	} {
+		bindings, err := config.PortConfig{Container: "80/tcp", Local: exportLocalPort(b)}.Bindings()
+		if err != nil {
+			t.Fatalf("%v: Unable to parse exported port: %s", b, err)
+		}
+
+		if got := bindings[0]; got.HostIP != b.HostIP || got.HostPort != b.HostPort {
+			t.Errorf("%v: Exported port resolves to %s:%s", b, got.HostIP, got.HostPort)
+		}
+	}
+}
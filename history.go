@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const historyLogTailLines = "1000"
+
+// jobRun represents a single finished run of a scheduled container
+type jobRun struct {
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	ImageID       string    `json:"image_id"`
+	Attempt       int       `json:"attempt"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	ExitCode      int       `json:"exit_code"`
+	OOMKilled     bool      `json:"oom_killed"`
+	TimedOut      bool      `json:"timed_out"`
+	StopReason    string    `json:"stop_reason,omitempty"`
+	Stdout        string    `json:"stdout,omitempty"`
+	Stderr        string    `json:"stderr,omitempty"`
+}
+
+func (j jobRun) Succeeded() bool {
+	return j.ExitCode == 0 && !j.OOMKilled && !j.TimedOut && j.StopReason == ""
+}
+
+type jobHistory map[string][]jobRun
+
+type historyRetention struct {
+	MaxRuns       int
+	MaxAge        time.Duration
+	MaxOutputSize int
+}
+
+// tailBuffer keeps only the last Size bytes written to it
+type tailBuffer struct {
+	Size int
+	buf  []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.Size {
+		t.buf = t.buf[len(t.buf)-t.Size:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string { return string(t.buf) }
+
+func loadJobHistory(filename string) (jobHistory, error) {
+	history := jobHistory{}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read job history: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("Unable to parse job history: %s", err)
+	}
+
+	return history, nil
+}
+
+func (h jobHistory) save(filename string) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal job history: %s", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Unable to create job history dir: %s", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write job history: %s", err)
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// add stores the run and applies the retention limits to the job
+func (h jobHistory) add(job string, run jobRun, retention historyRetention) {
+	runs := []jobRun{run}
+	for _, r := range h[job] {
+		// Runs recorded before their removal are reported again by the die event
+		if r.ContainerID != run.ContainerID {
+			runs = append(runs, r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+
+	kept := []jobRun{}
+	for _, r := range runs {
+		if retention.MaxRuns > 0 && len(kept) >= retention.MaxRuns {
+			break
+		}
+		if retention.MaxAge > 0 && time.Since(r.FinishedAt) > retention.MaxAge {
+			continue
+		}
+		kept = append(kept, r)
+	}
+
+	h[job] = kept
+}
+
+// EnableJobHistory records every finished run of a scheduled container
+// into the given file
+func (s *scheduler) EnableJobHistory(filename string, retention historyRetention) error {
+	s.lock(lockHistory, true)
+	defer s.unlock(lockHistory, true)
+
+	history, err := loadJobHistory(filename)
+	if err != nil {
+		return err
+	}
+
+	s.history = history
+	s.historyFile = filename
+	s.historyRetention = retention
+
+	return nil
+}
+
+func (s *scheduler) recordJobRun(id string) {
+	s.lock(lockContainers, false)
+	cont, ok := s.knownContainers[id]
+	s.unlock(lockContainers, false)
+
+	if ok {
+		s.recordRun(id, cont)
+	}
+}
+
+// recordRun stores the finished run of a scheduled container. It needs
+// to be called before the container is removed as its logs are fetched.
+func (s *scheduler) recordRun(id string, cont container) {
+	s.lock(lockHistory, false)
+	enabled := s.historyFile != ""
+	s.unlock(lockHistory, false)
+
+	if !enabled || !cont.IsScheduled || cont.Container.State.Running {
+		return
+	}
+
+	stdout := &tailBuffer{Size: s.historyRetention.MaxOutputSize}
+	stderr := &tailBuffer{Size: s.historyRetention.MaxOutputSize}
+	if err := s.client.Logs(docker.LogsOptions{
+		Container:    id,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         historyLogTailLines,
+		RawTerminal:  cont.Container.Config.Tty,
+	}); err != nil {
+		log.Errorf("Unable to fetch logs of %q for job history: %s", cont.Container.Name, err)
+	}
+
+	attempt, _ := strconv.Atoi(cont.Container.Config.Labels[labelAttempt])
+
+	reason := s.stopReason(id)
+
+	run := jobRun{
+		ContainerID:   id,
+		ContainerName: strings.TrimLeft(cont.Container.Name, "/"),
+		ImageID:       cont.Container.Image,
+		Attempt:       attempt,
+		StartedAt:     cont.Container.State.StartedAt,
+		FinishedAt:    cont.Container.State.FinishedAt,
+		ExitCode:      cont.Container.State.ExitCode,
+		OOMKilled:     cont.Container.State.OOMKilled,
+		TimedOut:      reason == stopReasonTimeout,
+		StopReason:    reason,
+		Stdout:        stdout.String(),
+		Stderr:        stderr.String(),
+	}
+
+	logger := log.WithFields(log.Fields{
+		"job":       cont.ConfigName,
+		"container": run.ContainerName,
+		"exit_code": run.ExitCode,
+		"oom":       run.OOMKilled,
+		"timed_out": run.TimedOut,
+		"duration":  run.FinishedAt.Sub(run.StartedAt),
+	})
+	switch {
+	case run.Succeeded():
+		logger.Infof("Job finished")
+	case run.StopReason != "" && !run.TimedOut:
+		logger.Infof("Job was stopped (%s)", run.StopReason)
+	default:
+		logger.Warnf("Job failed")
+	}
+
+	s.lock(lockHistory, true)
+	defer s.unlock(lockHistory, true)
+
+	s.history.add(cont.ConfigName, run, s.historyRetention)
+	if err := s.history.save(s.historyFile); err != nil {
+		log.Errorf("Unable to store job history: %s", err)
+	}
+}
+
+// printJobHistory implements the `history [job]` command: Without a job
+// an overview of all recorded runs is printed, with a job the details
+// including the captured output of its runs are printed.
+func printJobHistory(filename string, args []string) error {
+	history, err := loadJobHistory(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		jobs := []string{}
+		for job := range history {
+			jobs = append(jobs, job)
+		}
+		sort.Strings(jobs)
+
+		fmt.Printf("%-25s %-20s %-12s %-5s %s\n", "JOB", "STARTED", "DURATION", "EXIT", "STATUS")
+		for _, job := range jobs {
+			for _, r := range history[job] {
+				fmt.Printf("%-25s %-20s %-12s %-5d %s\n",
+					job, r.StartedAt.Local().Format("2006-01-02 15:04:05"),
+					r.FinishedAt.Sub(r.StartedAt).Round(time.Second), r.ExitCode, r.status())
+			}
+		}
+		return nil
+	}
+
+	runs, ok := history[args[0]]
+	if !ok {
+		return fmt.Errorf("No runs recorded for job %q", args[0])
+	}
+
+	for _, r := range runs {
+		fmt.Printf("=== %s (%s)\n", r.ContainerName, r.ContainerID)
+		fmt.Printf("Image:    %s\n", r.ImageID)
+		fmt.Printf("Started:  %s\n", r.StartedAt.Local())
+		fmt.Printf("Finished: %s\n", r.FinishedAt.Local())
+		fmt.Printf("Exit:     %d (%s)\n", r.ExitCode, r.status())
+		fmt.Printf("Attempt:  %d\n", r.Attempt)
+		fmt.Printf("--- stdout\n%s\n--- stderr\n%s\n\n", r.Stdout, r.Stderr)
+	}
+
+	return nil
+}
+
+func (j jobRun) status() string {
+	switch {
+	case j.TimedOut:
+		return "timed out"
+	case j.StopReason != "":
+		return "stopped: " + j.StopReason
+	case j.OOMKilled:
+		return "OOM killed"
+	case j.ExitCode != 0:
+		return "failed"
+	}
+	return "success"
+}
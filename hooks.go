@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const hookOutputSize = 4096
+
+// runHooks executes the given hooks for the container in order. If a
+// hook with the abort failure policy fails the remaining hooks are not
+// executed and an error is returned. Failures of other hooks are only
+// logged.
+func (s *scheduler) runHooks(stage string, hooks []config.HookConfig, cont *docker.Container, ccfg *config.ContainerConfig) error {
+	for i, hook := range hooks {
+		logger := log.WithFields(log.Fields{
+			"container": strings.TrimLeft(cont.Name, "/"),
+			"hook":      fmt.Sprintf("%s[%d]", stage, i),
+		})
+
+		var err error
+		if hook.Type == config.HookTypeContainer {
+			err = s.runHookContainer(hook, cont, ccfg)
+		} else {
+			err = s.runHookExec(hook, cont)
+		}
+
+		switch {
+		case err == nil:
+			logger.Debugf("Hook executed successfully")
+		case hook.AbortOnFailure():
+			logger.Errorf("Hook failed: %s", err)
+			return fmt.Errorf("Hook %s[%d] failed: %s", stage, i, err)
+		default:
+			logger.Warnf("Hook failed, ignoring: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// runHookExec executes the hook command inside the running container
+func (s *scheduler) runHookExec(hook config.HookConfig, cont *docker.Container) error {
+	timeout, err := hook.TimeoutDuration()
+	if err != nil {
+		return err
+	}
+
+	exec, err := s.client.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          hook.Command,
+		Container:    cont.ID,
+		Env:          hook.Environment,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to create exec: %s", err)
+	}
+
+	output := &tailBuffer{Size: hookOutputSize}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.client.StartExec(exec.ID, docker.StartExecOptions{
+			OutputStream: output,
+			ErrorStream:  output,
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("Unable to execute: %s", err)
+		}
+	case <-time.After(timeout):
+		// The API provides no way to kill the exec, it is left behind
+		return fmt.Errorf("Command did not finish within %s and keeps running inside the container", timeout)
+	}
+
+	res, err := s.client.InspectExec(exec.ID)
+	if err != nil {
+		return fmt.Errorf("Unable to inspect exec: %s", err)
+	}
+
+	if res.ExitCode != 0 {
+		return fmt.Errorf("Command exited with code %d: %s", res.ExitCode, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// runHookContainer executes the hook command in a short-lived helper
+// container using the volumes of the container
+func (s *scheduler) runHookContainer(hook config.HookConfig, cont *docker.Container, ccfg *config.ContainerConfig) error {
+	timeout, err := hook.TimeoutDuration()
+	if err != nil {
+		return err
+	}
+
+	image := cont.Config.Image
+	if hook.Image != "" {
+		image = hook.ImageName()
+		if s.getImageByName(image) == nil {
+			log.Debugf("Image %q for hook of container %q not found, pulling now.", image, cont.Name)
+			s.pullImage(hook.Image, hook.ImageTag())
+		}
+	}
+
+	// Hooks get the environment the container was rendered with
+	index, _ := strconv.Atoi(cont.Config.Labels[labelReplica])
+	env, err := ccfg.ReplicaEnvironment(cont.Config.Labels[labelConfigName], index)
+	if err != nil {
+		return err
+	}
+
+	helper, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: fmt.Sprintf("%s-hook-%d", strings.TrimLeft(cont.Name, "/"), time.Now().UnixNano()),
+		Config: &docker.Config{
+			AttachStdout: true,
+			AttachStderr: true,
+			Image:        image,
+			Cmd:          hook.Command,
+			Env:          append(append([]string{}, env...), hook.Environment...),
+			Labels: map[string]string{
+				labelIsManaged: strTrue,
+				labelIsHook:    strTrue,
+			},
+		},
+		HostConfig: &docker.HostConfig{
+			VolumesFrom: []string{cont.ID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to create helper container: %s", err)
+	}
+
+	defer func() {
+		if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    helper.ID,
+			Force: true,
+		}); err != nil {
+			log.Errorf("Unable to remove hook container %q: %s", helper.Name, err)
+		}
+	}()
+
+	if err := s.client.StartContainer(helper.ID, nil); err != nil {
+		return fmt.Errorf("Unable to start helper container: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	exitCode, err := s.client.WaitContainerWithContext(helper.ID, ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("Command did not finish within %s", timeout)
+		}
+		return fmt.Errorf("Unable to wait for helper container: %s", err)
+	}
+
+	if exitCode != 0 {
+		output := &tailBuffer{Size: hookOutputSize}
+		if err := s.client.Logs(docker.LogsOptions{
+			Container:    helper.ID,
+			OutputStream: output,
+			ErrorStream:  output,
+			Stdout:       true,
+			Stderr:       true,
+		}); err != nil {
+			log.Errorf("Unable to fetch logs of hook container %q: %s", helper.Name, err)
+		}
+		return fmt.Errorf("Command exited with code %d: %s", exitCode, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
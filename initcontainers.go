@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+var errInitRunning = errors.New("Init containers are already running")
+
+// maxInitBackoff limits the wait time between two runs of failing init
+// containers
+const maxInitBackoff = time.Hour
+
+// initFailure tracks the failed runs of the init containers for a
+// configuration to back off between the retries
+type initFailure struct {
+	Checksum string
+	Attempts int
+	RetryAt  time.Time
+}
+
+// EnableInitPersistence loads the configurations the init containers
+// already succeeded for from the given file and keeps the file updated
+// so the init containers are not executed again after a restart
+func (s *scheduler) EnableInitPersistence(filename string) error {
+	s.lock(lockInit, true)
+	defer s.unlock(lockInit, true)
+
+	s.initStateFile = filename
+
+	data, err := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("Unable to read init container state: %s", err)
+	}
+
+	done := map[string]string{}
+	if err := json.Unmarshal(data, &done); err != nil {
+		return fmt.Errorf("Unable to parse init container state: %s", err)
+	}
+
+	for name, cs := range done {
+		if _, ok := s.initDone[name]; !ok {
+			s.initDone[name] = cs
+		}
+	}
+
+	return nil
+}
+
+// saveInitState writes the configurations the init containers succeeded
+// for to disk. The caller needs to hold the init lock.
+func (s *scheduler) saveInitState() {
+	if s.initStateFile == "" {
+		return
+	}
+
+	data, err := json.Marshal(s.initDone)
+	if err != nil {
+		log.Errorf("Unable to marshal init container state: %s", err)
+		return
+	}
+
+	if err := os.MkdirAll(path.Dir(s.initStateFile), 0700); err != nil {
+		log.Errorf("Unable to create init container state dir: %s", err)
+		return
+	}
+
+	tmp := s.initStateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Errorf("Unable to write init container state: %s", err)
+		return
+	}
+
+	if err := os.Rename(tmp, s.initStateFile); err != nil {
+		log.Errorf("Unable to write init container state: %s", err)
+	}
+}
+
+// initContainersDone checks whether the init containers of the
+// container already ran successfully for its current configuration
+func (s *scheduler) initContainersDone(name string, ccfg *config.ContainerConfig) bool {
+	if len(ccfg.InitContainers) == 0 {
+		return true
+	}
+
+	cs, err := ccfg.Checksum()
+	if err != nil {
+		return false
+	}
+
+	// An existing container having the same configuration was started
+	// after the init containers ran for this configuration
+	for _, instance := range ccfg.InstanceNames(name) {
+		if cont := s.getContainerByName(instance); cont != nil && cont.Config.Labels[labelConfigHash] == cs {
+			return true
+		}
+	}
+
+	s.lock(lockInit, false)
+	defer s.unlock(lockInit, false)
+
+	return s.initDone[name] == cs
+}
+
+// startInitContainers starts executing the init containers in the
+// background unless they are already running or failed recently for
+// the same configuration
+func (s *scheduler) startInitContainers(name string, ccfg *config.ContainerConfig) {
+	cs, err := ccfg.Checksum()
+	if err != nil {
+		log.Errorf("Unable to calculate checksum for %q: %s", name, err)
+		return
+	}
+
+	s.lock(lockInit, false)
+	failure, failed := s.initFailures[name]
+	s.unlock(lockInit, false)
+
+	if failed && failure.Checksum == cs && time.Now().Before(failure.RetryAt) {
+		return
+	}
+
+	go func() {
+		err := s.runInitContainers(name, ccfg)
+		if err == nil || err == errInitRunning {
+			return
+		}
+
+		retryAt := s.recordInitFailure(name, cs, ccfg.Retry.BackoffDuration())
+		log.Errorf("Init containers of %q failed, retrying at %s: %s", name, retryAt.Format(time.RFC3339), err)
+	}()
+}
+
+// recordInitFailure stores a failed run of the init containers and
+// returns the time of the next try. The wait time starts with the
+// backoff and is doubled for every further failure of the same
+// configuration.
+func (s *scheduler) recordInitFailure(name, checksum string, backoff time.Duration) time.Time {
+	s.lock(lockInit, true)
+	defer s.unlock(lockInit, true)
+
+	failure := s.initFailures[name]
+	if failure.Checksum != checksum {
+		failure = initFailure{Checksum: checksum}
+	}
+	failure.Attempts++
+
+	wait := backoff
+	for i := 1; i < failure.Attempts && wait < maxInitBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxInitBackoff {
+		wait = maxInitBackoff
+	}
+
+	failure.RetryAt = time.Now().Add(wait)
+	s.initFailures[name] = failure
+
+	return failure.RetryAt
+}
+
+// runInitContainers executes the init containers one after another and
+// records the configuration they ran for if all of them succeeded. If
+// the init containers are already running errInitRunning is returned.
+func (s *scheduler) runInitContainers(name string, ccfg *config.ContainerConfig) error {
+	s.lock(lockInit, true)
+	if s.initRunning[name] {
+		s.unlock(lockInit, true)
+		return errInitRunning
+	}
+	s.initRunning[name] = true
+	s.unlock(lockInit, true)
+
+	defer func() {
+		s.lock(lockInit, true)
+		defer s.unlock(lockInit, true)
+		delete(s.initRunning, name)
+	}()
+
+	cs, err := ccfg.Checksum()
+	if err != nil {
+		return fmt.Errorf("Unable to calculate checksum: %s", err)
+	}
+
+	for i, ic := range ccfg.InitContainers {
+		initName := fmt.Sprintf("%s-init-%d", name, i+1)
+		if err := s.runInitContainer(name, initName, ic); err != nil {
+			return fmt.Errorf("Init container %q: %s", initName, err)
+		}
+	}
+
+	s.lock(lockInit, true)
+	defer s.unlock(lockInit, true)
+	s.initDone[name] = cs
+	delete(s.initFailures, name)
+	s.saveInitState()
+
+	return nil
+}
+
+func (s *scheduler) runInitContainer(cfgName, name string, ic config.InitContainerConfig) error {
+	image := ic.Image + ":" + ic.ImageTag()
+	timeout, err := ic.TimeoutDuration()
+	if err != nil {
+		return err
+	}
+
+	if s.getImageByName(image) == nil {
+		log.Debugf("Image %q for init container %q not found, pulling now.", image, name)
+		s.pullImage(ic.Image, ic.ImageTag())
+	}
+
+	if old := s.getContainerByName(name); old != nil {
+		// Leftover of a previous run
+		if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    old.ID,
+			Force: true,
+		}); err != nil {
+			return fmt.Errorf("Unable to remove previous container: %s", err)
+		}
+	}
+
+	volumes, binds := parseMounts(ic.Volumes)
+
+	log.Infof("Starting init container %q...", name)
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: name,
+		Config: &docker.Config{
+			AttachStdout: true,
+			AttachStderr: true,
+			Image:        image,
+			Cmd:          ic.Command,
+			Env:          ic.Environment,
+			Volumes:      volumes,
+			Labels: map[string]string{
+				labelIsManaged:  strTrue,
+				labelIsInit:     strTrue,
+				labelConfigName: cfgName,
+			},
+		},
+		HostConfig: &docker.HostConfig{
+			Binds: binds,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to create container: %s", err)
+	}
+
+	if err := s.client.StartContainer(cont.ID, nil); err != nil {
+		return fmt.Errorf("Unable to start container: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	exitCode, err := s.client.WaitContainerWithContext(cont.ID, ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			return fmt.Errorf("Unable to wait for container: %s", err)
+		}
+
+		if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    cont.ID,
+			Force: true,
+		}); err != nil {
+			log.Errorf("Unable to remove init container %q: %s", name, err)
+		}
+		return fmt.Errorf("Container did not finish within %s and was killed", timeout)
+	}
+
+	if exitCode != 0 {
+		// Container is kept for inspection and removed by the next run
+		return fmt.Errorf("Container exited with code %d", exitCode)
+	}
+
+	if err := s.client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID}); err != nil {
+		log.Errorf("Unable to remove init container %q: %s", name, err)
+	}
+
+	return nil
+}
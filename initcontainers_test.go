@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newInitTestScheduler() *scheduler {
+	return &scheduler{
+		locks:        map[string]*sync.RWMutex{},
+		initDone:     map[string]string{},
+		initFailures: map[string]initFailure{},
+	}
+}
+
+func TestInitStatePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "init")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := path.Join(dir, "init-containers.json")
+
+	s := newInitTestScheduler()
+	if err := s.EnableInitPersistence(stateFile); err != nil {
+		t.Fatalf("Unable to enable persistence: %s", err)
+	}
+	s.initDone["web"] = "abc"
+	s.saveInitState()
+
+	restarted := newInitTestScheduler()
+	if err := restarted.EnableInitPersistence(stateFile); err != nil {
+		t.Fatalf("Unable to load state: %s", err)
+	}
+	if restarted.initDone["web"] != "abc" {
+		t.Errorf("State was not restored: %v", restarted.initDone)
+	}
+}
+
+func TestInitFailureBackoff(t *testing.T) {
+	s := newInitTestScheduler()
+
+	for i, expected := range []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute,
+	} {
+		retryAt := s.recordInitFailure("web", "abc", time.Minute)
+		if wait := time.Until(retryAt); wait > expected || wait < expected-time.Second {
+			t.Errorf("Failure %d: Expected wait of %s, got %s", i+1, expected, wait)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		s.recordInitFailure("web", "abc", time.Minute)
+	}
+	if wait := time.Until(s.initFailures["web"].RetryAt); wait > maxInitBackoff {
+		t.Errorf("Wait of %s exceeds the limit", wait)
+	}
+
+	// Changed configuration starts over
+	retryAt := s.recordInitFailure("web", "def", time.Minute)
+	if wait := time.Until(retryAt); wait > time.Minute {
+		t.Errorf("Changed configuration did not reset the backoff, got %s", wait)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path"
@@ -10,24 +12,65 @@ import (
 
 	"github.com/Luzifer/dockermanager/config"
 	"github.com/Luzifer/rconfig"
-	log "github.com/sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	cfg struct { // FIXME: Rename me to "cfg" after removing cfg
-		Config   string `default:"config.yaml" flag:"config,c" description:"Config file or URL to read the config from"`
-		LogLevel string `flag:"log-level" default:"info" description:"Set log level (debug, info, warning, error)"`
+		Config       string `default:"config.yaml" flag:"config,c" description:"Config file or URL to read the config from"`
+		ConfigFormat string `flag:"config-format" default:"auto" description:"Format of the config (dockermanager, compose, auto: compose for docker-compose.yml / compose.yml files)"`
+		LogLevel     string `flag:"log-level" default:"info" description:"Set log level (debug, info, warning, error)"`
 
 		DockerHost    string `default:"unix:///var/run/docker.sock" flag:"docker-host" env:"DOCKER_HOST" description:"Connection method to the docker server"`
 		DockerCertDir string `default:"" flag:"docker-certs" description:"Directory containing cert.pem, key.pem, ca.pem for the registry"`
 
+		ConfigGitVerify  bool   `flag:"config-git-verify" default:"false" description:"Require the HEAD commit of git config sources to carry a valid signature"`
+		ConfigS3Endpoint string `flag:"config-s3-endpoint" default:"" env:"S3_ENDPOINT" description:"Endpoint of the S3 compatible storage for s3:// config sources"`
+		ConfigS3Region   string `flag:"config-s3-region" default:"us-east-1" env:"AWS_REGION" description:"Region to use for s3:// config sources"`
+
+		ConfigHTTPTimeout   time.Duration `flag:"config-http-timeout" default:"30s" description:"Timeout for fetching the config via HTTP(S)"`
+		ConfigHTTPRetries   int           `flag:"config-http-retries" default:"3" description:"How often to retry fetching the config on network or server errors"`
+		ConfigHTTPBackoff   time.Duration `flag:"config-http-backoff" default:"1s" description:"Initial wait time between retries, doubled on every retry"`
+		ConfigHTTPHeaders   []string      `flag:"config-http-header" default:"" description:"Additional headers in format 'Name: value' to send when fetching the config"`
+		ConfigHTTPTokenFile string        `flag:"config-http-token-file" default:"" description:"File containing a bearer token to send when fetching the config"`
+		ConfigHTTPCert      string        `flag:"config-http-cert" default:"" description:"Client certificate to authenticate with when fetching the config"`
+		ConfigHTTPKey       string        `flag:"config-http-key" default:"" description:"Key for the client certificate"`
+		ConfigHTTPCA        string        `flag:"config-http-ca" default:"" description:"CA certificate to verify the config server with"`
+
+		ConfigPublicKeys []string `flag:"config-pubkey" default:"" description:"Minisign public key files to verify the config signature with (enables signature verification)"`
+
+		Blackouts []string `flag:"blackout" default:"" description:"Dates (2006-01-02) or date ranges (2006-01-02/2006-01-06) in which only critical containers are updated"`
+		Timezone  string   `flag:"timezone" default:"Local" description:"Timezone for start_times and update_times of containers not specifying their own timezone"`
+
+		StateDir string `flag:"state-dir" default:"/var/lib/dockermanager" description:"Directory to store local state in"`
+
 		ConfigLoadInterval   time.Duration `default:"10m" flag:"configInterval" description:"Sleep time to wait between config reloads"`
 		ImageRefreshInterval time.Duration `default:"30m" flag:"refreshInterval" description:"fetch new images every <N>"`
 
 		CleanupTTL time.Duration `flag:"cleanup-ttl" default:"1h" description:"Time to wait until images and containers gets cleaned up"`
 
-		ManageFullHost bool `default:"true" flag:"fullHost" description:"Manage all containers on host"`
+		ManageFullHost  bool `default:"true" flag:"fullHost" description:"Manage all containers on host"`
+		AdoptContainers bool `default:"false" flag:"adopt-containers" description:"Take over running containers not started by the dockermanager if they match their configuration"`
+
+		DriftInterval time.Duration `flag:"drift-interval" default:"10m" description:"Interval to check managed containers for manual modifications (0 to disable)"`
+		DriftEnforce  bool          `flag:"drift-enforce" default:"false" description:"Recreate containers having manual modifications"`
+
+		UpdateNotifyDelay time.Duration `flag:"update-notify-delay" default:"1h" description:"Time to reject pending updates of containers having update_policy notify before they are executed"`
+
+		HistoryRuns       int           `flag:"history-runs" default:"50" description:"Number of runs to keep in the job history per scheduled container"`
+		HistoryMaxAge     time.Duration `flag:"history-max-age" default:"720h" description:"Maximum age of runs in the job history"`
+		HistoryOutputSize int           `flag:"history-output-size" default:"16384" description:"Number of bytes of stdout / stderr to keep per run in the job history"`
+
+		StatusListen string `flag:"status-listen" default:"" description:"Address to serve the status API on (e.g. 127.0.0.1:3000, disabled if empty)"`
+
+		ContainerLogDriver  string   `flag:"container-log-driver" default:"" description:"Logging driver for containers not specifying their own (default: driver of the docker daemon)"`
+		ContainerLogOptions []string `flag:"container-log-opt" default:"" description:"Options for the container logging driver in format key=value"`
+
+		SecurityPolicy string `flag:"security-policy" default:"" description:"YAML file containing host-level restrictions for the security options of containers"`
+
+		SecretsDir     string `flag:"secrets-dir" default:"/run/dockermanager/secrets" description:"Directory (should be a tmpfs) to write container secrets to"`
+		SecretsKeyFile string `flag:"secrets-key" default:"" description:"File containing the passphrase to decrypt encrypted secrets"`
 
 		VersionAndExit bool `flag:"version" default:"false" description:"Print version information and exit"`
 	}
@@ -35,6 +78,10 @@ var (
 	dockerClient     *docker.Client
 	authConfig       *docker.AuthConfigurations
 	configReloadChan = make(chan os.Signal, 1)
+	configSource     config.Source
+	configIsStale    bool
+	composeChecksum  string
+	configPublicKeys []config.PublicKey
 	hostname         string
 
 	version = "dev"
@@ -60,50 +107,279 @@ func init() {
 
 // #### CONFIG ####
 func loadConfig() (config.Config, error) {
-	log.Debugf("Loading config...")
+	log.Debugf("Loading config from %s...", configSource)
 
-	var (
-		c   config.Config
-		err error
-	)
-	if _, err = os.Stat(cfg.Config); err == nil {
-		c, err = config.LoadConfigFromFile(cfg.Config)
-	} else {
-		c, err = config.LoadConfigFromURL(cfg.Config)
+	body, err := configSource.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	signer, _ := configSource.(config.SignatureLoader)
+	body, signature, err := verifyConfig(body, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseConfig(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if committer, ok := configSource.(config.Committer); ok {
+		committer.Commit()
+	}
+
+	if err := config.WriteCache(configCacheFile(), configSource.String(), body, signature); err != nil {
+		log.Warnf("Unable to store last known good configuration: %s", err)
+	}
+
+	markConfigAvailable()
+
+	return c, nil
+}
+
+// markConfigAvailable clears the stale state after the config source
+// could be fetched again
+func markConfigAvailable() {
+	if configIsStale {
+		log.Infof("Configuration source is available again, configuration is no longer stale")
+		configIsStale = false
+	}
+}
+
+func loadCachedConfig() (config.Config, error) {
+	cache, err := config.ReadCache(configCacheFile())
+	if err != nil {
+		return nil, err
+	}
+
+	if cache.Source != configSource.String() {
+		return nil, fmt.Errorf("Cached configuration belongs to %s, not to %s", cache.Source, configSource)
+	}
+
+	body, _, err := verifyConfig([]byte(cache.Body), cachedSignature(cache.Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseConfig(body)
+	if err != nil {
+		return nil, err
+	}
+
+	configIsStale = true
+	log.WithFields(log.Fields{
+		"source":     cache.Source,
+		"fetched_at": cache.FetchedAt,
+	}).Warnf("Using STALE cached configuration")
+
+	return c, nil
+}
+
+func loadConfigPublicKeys() error {
+	for _, fn := range cfg.ConfigPublicKeys {
+		if fn == "" {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("Unable to read public key %q: %s", fn, err)
+		}
+
+		key, err := config.ParsePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("Unable to parse public key %q: %s", fn, err)
+		}
+
+		configPublicKeys = append(configPublicKeys, key)
 	}
 
+	return nil
+}
+
+// verifyConfig checks the signature of the config when public keys are
+// configured and returns the signed content and its signature. An
+// embedded signature takes precedence over a detached one.
+func verifyConfig(body []byte, signer config.SignatureLoader) ([]byte, []byte, error) {
+	if len(configPublicKeys) == 0 {
+		return body, nil, nil
+	}
+
+	content, signature, embedded := config.SplitEmbeddedSignature(body)
+	if !embedded {
+		if signer == nil {
+			return nil, nil, config.ErrUnsigned
+		}
+
+		var err error
+		if signature, err = signer.LoadSignature(); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", config.ErrUnsigned, err)
+		}
+	}
+
+	if err := config.VerifySignature(content, signature, configPublicKeys); err != nil {
+		return nil, nil, fmt.Errorf("Refusing configuration: %s", err)
+	}
+
+	log.Debugf("Configuration signature is valid")
+	return content, signature, nil
+}
+
+type cachedSignature string
+
+func (c cachedSignature) LoadSignature() ([]byte, error) { return []byte(c), nil }
+
+func parseConfig(body []byte) (config.Config, error) {
+	body, err := convertConfig(body)
 	if err != nil {
-		return c, err
+		return nil, err
+	}
+
+	c, err := config.ParseConfig(body)
+	if err != nil {
+		return nil, err
 	}
 
 	if _, err := c.GetDependencyChain(); err != nil {
-		return c, fmt.Errorf("Calculating the dependency chain caused an error: %s", err)
+		return nil, fmt.Errorf("Calculating the dependency chain caused an error: %s", err)
 	}
 
 	return c, nil
 }
 
+// convertConfig translates docker-compose files into the dockermanager
+// config format if requested by the --config-format
+func convertConfig(body []byte) ([]byte, error) {
+	switch cfg.ConfigFormat {
+	case "dockermanager":
+		return body, nil
+	case "compose":
+	case "auto":
+		if !config.IsComposeLocation(cfg.Config) {
+			return body, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown config format %q", cfg.ConfigFormat)
+	}
+
+	var baseDir string
+	if fs, ok := configSource.(*config.FileSource); ok {
+		baseDir = path.Dir(fs.Filename)
+	}
+
+	checksum := fmt.Sprintf("%x", sha256.Sum256(body))
+
+	body, warnings, err := config.ConvertCompose(body, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Warnings are only repeated when the compose file changed
+	if checksum != composeChecksum {
+		for _, w := range warnings {
+			log.Warnf("Compose import: %s", w)
+		}
+		composeChecksum = checksum
+	}
+
+	return body, nil
+}
+
+func configCacheFile() string {
+	return path.Join(cfg.StateDir, "config-cache.json")
+}
+
+func watchConfig() {
+	w, ok := configSource.(config.Watcher)
+	if !ok {
+		return
+	}
+
+	changes := make(chan struct{})
+	if err := w.Watch(changes); err != nil {
+		log.Warnf("Unable to watch config source, relying on reload interval: %s", err)
+		return
+	}
+
+	go func() {
+		for range changes {
+			configReloadChan <- syscall.SIGHUP
+		}
+	}()
+}
+
+// #### COMMANDS ####
+
+func runCommand(cmd string, args []string) {
+	var err error
+
+	switch cmd {
+	case "history":
+		err = printJobHistory(jobHistoryFile(), args)
+	case "updates":
+		err = printPendingUpdates(pendingUpdatesFile())
+	case decisionApprove, decisionReject:
+		err = decideUpdate(pendingUpdatesFile(), decisionsDir(), cmd, args)
+	case "export":
+		err = exportContainers(args)
+	case "drift":
+		err = printDrift(driftFile())
+	default:
+		err = fmt.Errorf("Unknown command %q", cmd)
+	}
+
+	if err != nil {
+		log.Fatalf("Command %q failed: %s", cmd, err)
+	}
+}
+
+func newDockerClient() (*docker.Client, error) {
+	if cfg.DockerCertDir == "" {
+		return docker.NewClient(cfg.DockerHost)
+	}
+
+	return docker.NewTLSClient(
+		cfg.DockerHost,
+		path.Join(cfg.DockerCertDir, "cert.pem"),
+		path.Join(cfg.DockerCertDir, "key.pem"),
+		path.Join(cfg.DockerCertDir, "ca.pem"),
+	)
+}
+
+func jobHistoryFile() string {
+	return path.Join(cfg.StateDir, "history.json")
+}
+
+func pendingUpdatesFile() string {
+	return path.Join(cfg.StateDir, "pending-updates.json")
+}
+
+func driftFile() string {
+	return path.Join(cfg.StateDir, "drift.json")
+}
+
+func decisionsDir() string {
+	return path.Join(cfg.StateDir, "decisions")
+}
+
 // #### MAIN ####
 
 func main() {
 	var err error
+
+	if args := rconfig.Args(); len(args) > 1 {
+		runCommand(args[1], args[2:])
+		return
+	}
+
 	signal.Notify(configReloadChan, syscall.SIGHUP)
 
 	if hostname, err = os.Hostname(); err != nil {
 		log.Fatalf("Unable to determine hostname: %s", err)
 	}
 
-	if cfg.DockerCertDir == "" {
-		dockerClient, err = docker.NewClient(cfg.DockerHost)
-	} else {
-		dockerClient, err = docker.NewTLSClient(
-			cfg.DockerHost,
-			path.Join(cfg.DockerCertDir, "cert.pem"),
-			path.Join(cfg.DockerCertDir, "key.pem"),
-			path.Join(cfg.DockerCertDir, "ca.pem"),
-		)
-	}
-	if err != nil {
+	if dockerClient, err = newDockerClient(); err != nil {
 		log.Fatalf("Unable to create Docker client: %s", err)
 	}
 
@@ -116,9 +392,58 @@ func main() {
 		log.Warnf("Could not read authconfig, continuing without authentication: %s", err)
 	}
 
+	if err = config.SetDefaultTimezone(cfg.Timezone); err != nil {
+		log.Fatalf("Unable to set default timezone: %s", err)
+	}
+
+	if err = config.SetBlackouts(cfg.Blackouts); err != nil {
+		log.Fatalf("Unable to parse blackouts: %s", err)
+	}
+
+	if err = config.SetDefaultLogging(cfg.ContainerLogDriver, cfg.ContainerLogOptions); err != nil {
+		log.Fatalf("Unable to set default container logging: %s", err)
+	}
+
+	if err = config.LoadSecurityPolicy(cfg.SecurityPolicy); err != nil {
+		log.Fatalf("Unable to load security policy: %s", err)
+	}
+
+	if err = loadSecretsKey(); err != nil {
+		log.Fatalf("Unable to load secrets key: %s", err)
+	}
+
+	if err = loadSecretDigestKey(); err != nil {
+		log.Fatalf("Unable to load secret digest key: %s", err)
+	}
+
+	if err = loadConfigPublicKeys(); err != nil {
+		log.Fatalf("Unable to load config public keys: %s", err)
+	}
+
+	configSource, err = config.NewSource(cfg.Config, config.SourceOptions{
+		WorkDir:             cfg.StateDir,
+		GitVerifySignatures: cfg.ConfigGitVerify,
+		S3Endpoint:          cfg.ConfigS3Endpoint,
+		S3Region:            cfg.ConfigS3Region,
+		HTTPTimeout:         cfg.ConfigHTTPTimeout,
+		HTTPRetries:         cfg.ConfigHTTPRetries,
+		HTTPRetryBackoff:    cfg.ConfigHTTPBackoff,
+		HTTPHeaders:         cfg.ConfigHTTPHeaders,
+		HTTPBearerTokenFile: cfg.ConfigHTTPTokenFile,
+		HTTPClientCert:      cfg.ConfigHTTPCert,
+		HTTPClientKey:       cfg.ConfigHTTPKey,
+		HTTPCACert:          cfg.ConfigHTTPCA,
+	})
+	if err != nil {
+		log.Fatalf("Unable to initialize config source: %s", err)
+	}
+
 	configFile, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Initial configuration load failed: %s", err)
+		log.Errorf("Initial configuration load failed, trying last known good configuration: %s", err)
+		if configFile, err = loadCachedConfig(); err != nil {
+			log.Fatalf("Unable to load last known good configuration: %s", err)
+		}
 	}
 
 	sched, err := newScheduler(hostname, dockerClient, authConfig, configFile, cfg.ImageRefreshInterval)
@@ -132,7 +457,49 @@ func main() {
 		sched.EnableImageCleanup(cfg.CleanupTTL)
 	}
 
+	if err := sched.EnableJobHistory(jobHistoryFile(), historyRetention{
+		MaxRuns:       cfg.HistoryRuns,
+		MaxAge:        cfg.HistoryMaxAge,
+		MaxOutputSize: cfg.HistoryOutputSize,
+	}); err != nil {
+		log.Errorf("Unable to load job history, job runs will not be recorded: %s", err)
+	}
+
+	if cfg.StatusListen != "" {
+		sched.EnableStatusAPI(cfg.StatusListen)
+	}
+
+	notifyDelay := cfg.UpdateNotifyDelay
+	if notifyDelay < cfg.ConfigLoadInterval {
+		// Operators need at least one reload cycle to reject an update
+		log.Warnf("Update notify delay %s is shorter than the config reload interval, using %s", notifyDelay, cfg.ConfigLoadInterval)
+		notifyDelay = cfg.ConfigLoadInterval
+	}
+
+	if err := sched.EnableUpdateApproval(pendingUpdatesFile(), decisionsDir(), notifyDelay); err != nil {
+		log.Errorf("Unable to load pending updates, updates requiring approval are not executed: %s", err)
+	}
+
+	if cfg.AdoptContainers {
+		if err := sched.EnableAdoption(path.Join(cfg.StateDir, "adopted.json")); err != nil {
+			log.Errorf("Unable to load adopted containers, adoption is disabled: %s", err)
+		}
+	}
+
+	if cfg.DriftInterval > 0 {
+		sched.EnableDriftDetection(driftFile(), cfg.DriftInterval, cfg.DriftEnforce)
+	}
+
+	if err := sched.EnableInitPersistence(path.Join(cfg.StateDir, "init-containers.json")); err != nil {
+		log.Errorf("Unable to load init container state, init containers are executed again: %s", err)
+	}
+
+	if err := sched.EnableSchedulePersistence(path.Join(cfg.StateDir, "schedule.json")); err != nil {
+		log.Errorf("Unable to restore schedule state, starting with a fresh schedule: %s", err)
+	}
+
 	// Config reload
+	watchConfig()
 	go func() {
 		for range time.Tick(cfg.ConfigLoadInterval) {
 			configReloadChan <- syscall.SIGHUP
@@ -141,8 +508,18 @@ func main() {
 
 	for range configReloadChan {
 		configFile, err := loadConfig()
+		if err == config.ErrNotModified {
+			// Unchanged content still proves the source is available
+			markConfigAvailable()
+			log.Debugf("Configuration was not modified, nothing to do")
+			continue
+		}
 		if err != nil {
 			log.Errorf("Unable to reload configuration, old one is kept active: %s", err)
+			if !configIsStale {
+				log.Warnf("Configuration is STALE until the config source is available again")
+				configIsStale = true
+			}
 			continue
 		}
 		sched.UpdateConfiguration(configFile)
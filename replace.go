@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	startFirstHealthTimeout = 5 * time.Minute
+	startFirstSettleTime    = 10 * time.Second
+	startFirstPollInterval  = time.Second
+)
+
+// updateContainer executes the stop-first update strategy: The
+// container and all depending containers are stopped to be recreated by
+// the next startContainers run. Other replicas of the configuration are
+// updated independently.
+func (s *scheduler) updateContainer(name, instance string, ccfg *config.ContainerConfig, old *docker.Container) {
+	if !s.startReplacement(instance) {
+		return
+	}
+	defer s.finishReplacement(instance)
+
+	if err := s.runHooks("pre_update", ccfg.Hooks.PreUpdate, old, ccfg); err != nil {
+		log.Errorf("Update of container %q aborted: %s", instance, err)
+		return
+	}
+
+	s.lock(lockConfig, false)
+	defer s.unlock(lockConfig, false)
+
+	if err := s.stopDependingContainers(name); err != nil {
+		log.Errorf("Unable to stop containers depending on %q: %s", instance, err)
+		return
+	}
+
+	if err := s.stopInstance(instance, ccfg, stopReasonUpdate); err != nil {
+		log.Errorf("Unable to stop container %q: %s", instance, err)
+	}
+}
+
+// replaceContainer executes the start-first update strategy: A new
+// container is started next to the old one and only after it became
+// healthy the old one is stopped and the new one takes over its name.
+// If the new container does not become healthy the old one is kept
+// running and the update is not retried until the target changes.
+func (s *scheduler) replaceContainer(name, instance string, index int, target string, ccfg *config.ContainerConfig, old *docker.Container) {
+	if !s.startReplacement(instance) {
+		return
+	}
+	defer s.finishReplacement(instance)
+
+	logger := log.WithFields(log.Fields{"container": instance})
+
+	if err := s.runHooks("pre_update", ccfg.Hooks.PreUpdate, old, ccfg); err != nil {
+		logger.Errorf("Update aborted: %s", err)
+		return
+	}
+
+	if !s.initContainersDone(name, ccfg) {
+		// Init containers are shared by all replicas, errInitRunning makes
+		// this replica retry with the next check
+		if err := s.runInitContainers(name, ccfg); err != nil {
+			logger.Errorf("Init containers failed, retrying with the next check: %s", err)
+			return
+		}
+	}
+
+	tmpName := fmt.Sprintf("%s-update-%d", instance, time.Now().Unix())
+	cont, err := bootContainer(name, tmpName, index, ccfg)
+	if err != nil {
+		logger.Errorf("Unable to start replacement container: %s", err)
+		s.failReplacement(instance, target, nil)
+		return
+	}
+
+	if err := s.runHooks("post_start", ccfg.Hooks.PostStart, cont, ccfg); err != nil {
+		logger.Errorf("Post-start hooks of replacement container failed: %s", err)
+	}
+
+	cont, err = s.waitHealthy(cont.ID)
+	if err != nil {
+		logger.Errorf("Replacement container did not become healthy, keeping the old one: %s", err)
+		s.failReplacement(instance, target, cont)
+		return
+	}
+
+	logger.Infof("Replacement container is healthy, stopping the old one")
+	if err := s.stopContainer(old, ccfg, stopReasonUpdate); err != nil {
+		logger.Errorf("Unable to stop old container, keeping it: %s", err)
+		s.failReplacement(instance, target, cont)
+		return
+	}
+
+	if err := s.client.RemoveContainer(docker.RemoveContainerOptions{ID: old.ID}); err != nil {
+		logger.Errorf("Unable to remove old container: %s", err)
+	} else {
+		removeSecrets(old)
+	}
+
+	if err := s.client.RenameContainer(docker.RenameContainerOptions{ID: cont.ID, Name: instance}); err != nil {
+		// The next check will start a new container using the regular way
+		logger.Errorf("Unable to rename replacement container: %s", err)
+		s.failReplacement(instance, target, cont)
+		return
+	}
+
+	// Containers linking to the old container need to be recreated
+	s.lock(lockConfig, false)
+	defer s.unlock(lockConfig, false)
+
+	if err := s.stopDependingContainers(name); err != nil {
+		logger.Errorf("Unable to stop depending containers: %s", err)
+	}
+}
+
+// waitHealthy waits for the container to report a healthy state. If the
+// container has no health check it needs to stay running for the
+// settle time.
+func (s *scheduler) waitHealthy(id string) (*docker.Container, error) {
+	var (
+		cont     *docker.Container
+		err      error
+		deadline = time.Now().Add(startFirstHealthTimeout)
+	)
+
+	for time.Now().Before(deadline) {
+		if cont, err = s.client.InspectContainer(id); err != nil {
+			return nil, err
+		}
+
+		if !cont.State.Running {
+			return cont, fmt.Errorf("Container exited with code %d", cont.State.ExitCode)
+		}
+
+		switch cont.State.Health.Status {
+		case "healthy":
+			return cont, nil
+		case "unhealthy":
+			return cont, fmt.Errorf("Container reported unhealthy state")
+		case "":
+			if time.Since(cont.State.StartedAt) > startFirstSettleTime {
+				return cont, nil
+			}
+		}
+
+		time.Sleep(startFirstPollInterval)
+	}
+
+	return cont, fmt.Errorf("Container was not healthy after %s", startFirstHealthTimeout)
+}
+
+// startReplacement marks the container to be updated and returns false
+// if there is already an update active
+func (s *scheduler) startReplacement(name string) bool {
+	s.lock(lockReplacements, true)
+	defer s.unlock(lockReplacements, true)
+
+	if s.replacing[name] {
+		return false
+	}
+
+	delete(s.failedReplacements, name)
+	s.replacing[name] = true
+	return true
+}
+
+func (s *scheduler) finishReplacement(name string) {
+	s.lock(lockReplacements, true)
+	defer s.unlock(lockReplacements, true)
+
+	delete(s.replacing, name)
+}
+
+// failReplacement removes the replacement container and remembers the
+// target to not try it again
+func (s *scheduler) failReplacement(name, target string, cont *docker.Container) {
+	s.lock(lockReplacements, true)
+	s.failedReplacements[name] = target
+	s.unlock(lockReplacements, true)
+
+	if cont == nil {
+		return
+	}
+
+	if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    cont.ID,
+		Force: true,
+	}); err != nil {
+		log.Errorf("Unable to remove replacement container %q: %s", cont.Name, err)
+		return
+	}
+	removeSecrets(cont)
+}
+
+// replacementFailed checks whether a start-first replacement to the
+// given target already failed
+func (s *scheduler) replacementFailed(name, target string) bool {
+	s.lock(lockReplacements, false)
+	defer s.unlock(lockReplacements, false)
+
+	return s.failedReplacements[name] == target
+}
+
+// isReplacing checks whether an update of the container configuration
+// is in progress
+func (s *scheduler) isReplacing(name string) bool {
+	s.lock(lockReplacements, false)
+	defer s.unlock(lockReplacements, false)
+
+	return s.replacing[strings.TrimLeft(name, "/")]
+}
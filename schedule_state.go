@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/Luzifer/dockermanager/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// EnableSchedulePersistence loads the scheduling state of the scheduled
+// containers from the given file, applies the catch-up policies for runs
+// missed while the daemon was not running and keeps the file updated
+func (s *scheduler) EnableSchedulePersistence(filename string) error {
+	s.lock(lockConfig, true)
+	defer s.unlock(lockConfig, true)
+
+	s.scheduleStateFile = filename
+
+	states := map[string]config.ScheduleState{}
+	data, err := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(err):
+		// No state yet, nothing to restore
+	case err != nil:
+		return fmt.Errorf("Unable to read schedule state: %s", err)
+	default:
+		if err := json.Unmarshal(data, &states); err != nil {
+			return fmt.Errorf("Unable to parse schedule state: %s", err)
+		}
+	}
+
+	for name, state := range states {
+		ccfg, ok := s.config[name]
+		if !ok {
+			continue
+		}
+
+		if err := ccfg.RestoreScheduleState(state, true); err != nil {
+			return fmt.Errorf("Unable to restore schedule state of %q: %s", name, err)
+		}
+	}
+
+	s.saveScheduleState()
+	return nil
+}
+
+// saveScheduleState writes the scheduling state of all scheduled
+// containers to disk. The caller needs to hold the config lock.
+func (s *scheduler) saveScheduleState() {
+	if s.scheduleStateFile == "" {
+		return
+	}
+
+	states := map[string]config.ScheduleState{}
+	for name, ccfg := range s.config {
+		if ccfg.StartTimes != "" {
+			states[name] = ccfg.ScheduleState()
+		}
+	}
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		log.Errorf("Unable to marshal schedule state: %s", err)
+		return
+	}
+
+	if err := os.MkdirAll(path.Dir(s.scheduleStateFile), 0700); err != nil {
+		log.Errorf("Unable to create schedule state dir: %s", err)
+		return
+	}
+
+	tmp := s.scheduleStateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Errorf("Unable to write schedule state: %s", err)
+		return
+	}
+
+	if err := os.Rename(tmp, s.scheduleStateFile); err != nil {
+		log.Errorf("Unable to write schedule state: %s", err)
+	}
+}
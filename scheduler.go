@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,10 +19,24 @@ const (
 	imageManagerInterval     = time.Minute
 	containerManagerInterval = time.Minute
 
-	lockConfig     = "config"
-	lockContainers = "containers"
-	lockImages     = "images"
-	lockPullDict   = "pullDict"
+	lockConfig       = "config"
+	lockContainers   = "containers"
+	lockImages       = "images"
+	lockHistory      = "history"
+	lockJobs         = "jobs"
+	lockPostponed    = "postponed"
+	lockUpdates      = "updates"
+	lockReplacements = "replacements"
+	lockInit         = "init"
+	lockAdoption     = "adoption"
+	lockDrift        = "drift"
+	lockPullDict     = "pullDict"
+
+	// Reasons for the dockermanager to stop a container
+	stopReasonTimeout  = "timed out"
+	stopReasonUpdate   = "update"
+	stopReasonReplaced = "replaced by a new run"
+	stopReasonRemoved  = "not configured"
 )
 
 var (
@@ -30,9 +45,11 @@ var (
 
 type container struct {
 	Checksum    string
+	ConfigName  string
 	Container   *docker.Container
 	IsManaged   bool
 	IsScheduled bool
+	Replica     int
 }
 
 type image struct {
@@ -55,11 +72,33 @@ type scheduler struct {
 	cleanupMinAge        time.Duration
 	client               *docker.Client
 	config               config.Config
+	history              jobHistory
+	historyFile          string
+	historyRetention     historyRetention
 	hostname             string
 	imageRefreshInterval time.Duration
 	knownContainers      map[string]container
 	knownImages          map[string]image
 	listener             chan *docker.APIEvents
+	scheduleStateFile    string
+	stopReasons          map[string]string
+	postponedUpdates     map[string]time.Time
+	pendingUpdates       pendingUpdates
+	pendingUpdatesFile   string
+	notifyDelay          time.Duration
+	decisionsDir         string
+	replacing            map[string]bool
+	failedReplacements   map[string]string
+	initDone             map[string]string
+	initRunning          map[string]bool
+	initFailures         map[string]initFailure
+	initStateFile        string
+	adopted              adoptedContainers
+	adoptedFile          string
+	adoptionReported     map[string]string
+	drift                driftRecords
+	driftFile            string
+	driftEnforce         bool
 
 	locks     map[string]*sync.RWMutex
 	locksLock sync.Mutex
@@ -77,6 +116,13 @@ func newScheduler(hostname string, client *docker.Client, authConfig *docker.Aut
 		knownContainers:      make(map[string]container),
 		knownImages:          make(map[string]image),
 		listener:             make(chan *docker.APIEvents, 10),
+		stopReasons:          make(map[string]string),
+		postponedUpdates:     make(map[string]time.Time),
+		replacing:            make(map[string]bool),
+		failedReplacements:   make(map[string]string),
+		initDone:             make(map[string]string),
+		initRunning:          make(map[string]bool),
+		initFailures:         make(map[string]initFailure),
 
 		locks:    make(map[string]*sync.RWMutex),
 		pullLock: make(map[string]bool),
@@ -128,7 +174,18 @@ func (s *scheduler) UpdateConfiguration(cfg config.Config) {
 	s.lock(lockConfig, true)
 	defer s.unlock(lockConfig, true)
 
+	// Take over the schedule of containers whose start_times did not
+	// change to prevent pushing their next run into the future
+	for name, ccfg := range cfg {
+		if old, ok := s.config[name]; ok {
+			if err := ccfg.RestoreScheduleState(old.ScheduleState(), false); err != nil {
+				log.Errorf("Unable to take over schedule of %q: %s", name, err)
+			}
+		}
+	}
+
 	s.config = cfg
+	s.saveScheduleState()
 }
 
 func (s *scheduler) EnableImageCleanup(minAge time.Duration) {
@@ -228,15 +285,34 @@ func (s *scheduler) refreshImageInformation(id string, remove bool) error {
 
 func (s *scheduler) refreshContainerInformation(id string, remove bool) error {
 	if remove {
+		s.unmarkStopped(id)
+
+		s.forgetAdoption(id)
+
 		s.lock(lockContainers, true)
 		defer s.unlock(lockContainers, true)
 		delete(s.knownContainers, id)
 		return nil
 	}
 
+	c, err := s.inspectContainer(id)
+	if err != nil {
+		return err
+	}
+
+	s.lock(lockContainers, true)
+	defer s.unlock(lockContainers, true)
+	s.knownContainers[c.Container.ID] = c
+
+	return nil
+}
+
+// inspectContainer fetches the current state of the container and reads
+// the dockermanager information from its labels
+func (s *scheduler) inspectContainer(id string) (container, error) {
 	cont, err := s.client.InspectContainer(id)
 	if err != nil {
-		return fmt.Errorf("Unable to inspect container %q: %s", id, err)
+		return container{}, fmt.Errorf("Unable to inspect container %q: %s", id, err)
 	}
 
 	c := container{
@@ -247,11 +323,14 @@ func (s *scheduler) refreshContainerInformation(id string, remove bool) error {
 	_, c.IsScheduled = cont.Config.Labels[labelIsScheduled]
 	c.Checksum = cont.Config.Labels[labelConfigHash]
 
-	s.lock(lockContainers, true)
-	defer s.unlock(lockContainers, true)
-	s.knownContainers[cont.ID] = c
+	c.ConfigName = cont.Config.Labels[labelConfigName]
+	if c.ConfigName == "" {
+		// Containers created by older versions are named like their config
+		c.ConfigName = strings.TrimLeft(cont.Name, "/")
+	}
+	c.Replica, _ = strconv.Atoi(cont.Config.Labels[labelReplica])
 
-	return nil
+	return s.applyAdoption(c), nil
 }
 
 func (s *scheduler) getContainerByName(name string) *docker.Container {
@@ -290,7 +369,7 @@ func (s *scheduler) handleContainerEvent(evt *docker.APIEvents) error {
 		"copy":        dummyHandler,                                                                                    // FIXME: What's this?
 		"create":      func(evt *docker.APIEvents) error { return s.refreshContainerInformation(evt.Actor.ID, false) }, // Actor.ID is the ID of the container
 		"destroy":     func(evt *docker.APIEvents) error { return s.refreshContainerInformation(evt.Actor.ID, true) },  // Actor.ID is the ID of the container
-		"die":         func(evt *docker.APIEvents) error { return s.refreshContainerInformation(evt.Actor.ID, false) }, // Actor.ID is the ID of the container
+		"die":         s.handleContainerDie,                                                                            // Actor.ID is the ID of the container
 		"exec_create": dummyHandler,                                                                                    // No need to handle
 		"exec_start":  dummyHandler,                                                                                    // No need to handle
 		"export":      dummyHandler,                                                                                    // No need to handle
@@ -311,6 +390,66 @@ func (s *scheduler) handleContainerEvent(evt *docker.APIEvents) error {
 	return nil
 }
 
+func (s *scheduler) handleContainerDie(evt *docker.APIEvents) error {
+	if err := s.refreshContainerInformation(evt.Actor.ID, false); err != nil {
+		return err
+	}
+
+	// Fetching the logs may take a moment, don't block the event loop
+	go func(id string) {
+		s.handleJobResult(id)
+		s.recordJobRun(id)
+	}(evt.Actor.ID)
+	return nil
+}
+
+func (s *scheduler) handleJobResult(id string) {
+	s.lock(lockContainers, false)
+	cont, ok := s.knownContainers[id]
+	s.unlock(lockContainers, false)
+
+	if !ok || !cont.IsScheduled || cont.Container.State.Running {
+		return
+	}
+
+	s.lock(lockConfig, true)
+	defer s.unlock(lockConfig, true)
+
+	ccfg, ok := s.config[cont.ConfigName]
+	if !ok || ccfg.StartTimes == "" {
+		return
+	}
+	defer s.saveScheduleState()
+
+	if reason := s.stopReason(id); reason != "" {
+		// Stopped on purpose, the next regular run takes place as scheduled
+		log.WithFields(log.Fields{
+			"job":    cont.ConfigName,
+			"reason": reason,
+		}).Warnf("Job was stopped by the dockermanager, not retrying")
+		ccfg.ResetRetries()
+		return
+	}
+
+	state := cont.Container.State
+	if state.ExitCode == 0 && !state.OOMKilled {
+		ccfg.ResetRetries()
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"job":       cont.ConfigName,
+		"exit_code": state.ExitCode,
+		"attempt":   ccfg.Attempt(),
+	})
+
+	if retry, at := ccfg.ScheduleRetry(time.Now()); retry {
+		logger.Warnf("Job failed, retry %d of %d scheduled for %s", ccfg.Attempt(), ccfg.Retry.Attempts, at)
+	} else if ccfg.Retry.Attempts > 0 {
+		logger.Errorf("Job failed, no retries left")
+	}
+}
+
 func (s *scheduler) handleImageEvent(evt *docker.APIEvents) error {
 	if hdl, ok := map[string]apiEventHandlerFunction{
 		"delete": func(evt *docker.APIEvents) error { return s.refreshImageInformation(evt.Actor.ID, true) },  // Actor.ID is the ID (sha256:...) of the image
@@ -370,7 +509,9 @@ func (s *scheduler) containerManager() {
 	for range time.Tick(containerManagerInterval) {
 
 		s.removeDeadContainers()
+		s.adoptContainers()
 		s.stopUnexpectedContainers()
+		s.stopTimedOutJobs()
 		s.stopContainersWithUpdates()
 		s.startContainers()
 
@@ -398,7 +539,7 @@ func (s *scheduler) removeDeadContainers() {
 			continue
 		}
 
-		if _, ok := s.config[strings.TrimLeft(cont.Container.Name, "/")]; ok {
+		if s.config.IsInstance(cont.ConfigName, strings.TrimLeft(cont.Container.Name, "/")) {
 			// Container is still managed, remove will be done by startContainers
 			// This is to prevent two simultaneous remove calls which causes trouble
 			continue
@@ -408,15 +549,21 @@ func (s *scheduler) removeDeadContainers() {
 			ID: id,
 		}); err != nil {
 			log.Errorf("Unable to remove container %q: %s", cont.Container.Name, err)
+			continue
 		}
+
+		removeSecrets(cont.Container)
 	}
 }
 
 func (s *scheduler) stopUnexpectedContainers() {
+	s.lock(lockConfig, false)
+	defer s.unlock(lockConfig, false)
+
 	s.lock(lockContainers, false)
 	defer s.unlock(lockContainers, false)
 
-	for id, cont := range s.knownContainers {
+	for _, cont := range s.knownContainers {
 		if !cont.Container.State.Running {
 			// It's already dead
 			continue
@@ -432,13 +579,24 @@ func (s *scheduler) stopUnexpectedContainers() {
 			continue
 		}
 
-		if _, ok := s.config[strings.TrimLeft(cont.Container.Name, "/")]; !ok {
-			// We don't have a config for this one so lets ask it to stop
-			go func(id string, cont container) {
-				if err := s.client.StopContainer(id, 30); err != nil {
+		if s.isReplacing(config.InstanceName(cont.ConfigName, cont.Replica)) {
+			// Replacement container of a start-first update
+			continue
+		}
+
+		if cont.Container.Config.Labels[labelIsHook] == strTrue || cont.Container.Config.Labels[labelIsInit] == strTrue {
+			// Hook helper or init container, is removed after execution
+			continue
+		}
+
+		if !s.config.IsInstance(cont.ConfigName, strings.TrimLeft(cont.Container.Name, "/")) {
+			// We don't have a config for this one (or it is a replica not
+			// required anymore) so lets ask it to stop
+			go func(cont container, ccfg *config.ContainerConfig) {
+				if err := s.stopContainer(cont.Container, ccfg, stopReasonRemoved); err != nil {
 					log.Errorf("Unable to stop container %q: %s", cont.Container.Name, err)
 				}
-			}(id, cont)
+			}(cont, s.config[cont.ConfigName])
 		}
 	}
 }
@@ -447,54 +605,228 @@ func (s *scheduler) stopContainersWithUpdates() {
 	s.lock(lockContainers, false)
 	defer s.unlock(lockContainers, false)
 
-	for id, cont := range s.knownContainers {
+	var (
+		// Configurations having an update for at least one of their containers
+		updating = map[string]bool{}
+		// Replicated configurations already having an update started
+		rolling = map[string]bool{}
+	)
+
+	for _, cont := range s.knownContainers {
 		if !cont.Container.State.Running {
 			// It's already dead
 			continue
 		}
 
-		ccfg, ok := s.config[strings.TrimLeft(cont.Container.Name, "/")]
-		if !ok {
+		name := strings.TrimLeft(cont.Container.Name, "/")
+		if !s.config.IsInstance(cont.ConfigName, name) {
 			// We don't know about this one, not our job
 			continue
 		}
+		ccfg := s.config[cont.ConfigName]
+
+		stopIt := false
+		reasons := []string{}
+		cs, csErr := ccfg.Checksum()
+		if csErr != nil {
+			log.Errorf("Unable to calculate checksum for %q: %s", name, csErr)
+		}
+
+		if csErr == nil && cont.Checksum != "" && cs != cont.Checksum {
+			// Checksum mismatch: Ask it to go
+			reasons = append(reasons, "configuration update")
+			stopIt = true
+		}
+
+		img := s.getImageByName(ccfg.Image + ":" + ccfg.Tag)
+		if img != nil && img.ID != cont.Container.Image {
+			// Image was renewed: Ask it to go
+			reasons = append(reasons, "new image version")
+			stopIt = true
+		}
+
+		if s.driftEnforced(cont.Container.ID) {
+			// Container was modified manually: Recreate it
+			reasons = append(reasons, "configuration drift")
+			stopIt = true
+		}
+
+		if s.isReplacing(name) {
+			// Update is already in progress
+			updating[cont.ConfigName] = true
+			continue
+		}
+
+		if !stopIt {
+			continue
+		}
+		updating[cont.ConfigName] = true
+
+		pending := pendingUpdate{
+			Container:   cont.ConfigName,
+			OldImage:    cont.Container.Image,
+			NewImage:    cont.Container.Image,
+			OldChecksum: cont.Checksum,
+			NewChecksum: cs,
+		}
+		if img != nil {
+			pending.NewImage = img.ID
+		}
+		if !s.updateApproved(cont.ConfigName, ccfg, pending) {
+			// Update needs to wait for approval
+			continue
+		}
+
+		if ccfg.UpdateStrategy == config.UpdateStrategyStartFirst && s.replacementFailed(name, pending.Target()) {
+			// Replacement already failed for this update, wait for a new one
+			continue
+		}
 
 		if allowed, err := ccfg.UpdateAllowedAt(time.Now()); err == nil && !allowed {
-			// We may not update now, don't bother
+			// We may not update now, tell when we will
+			s.logPostponedUpdate(cont.Container.Name, ccfg)
 			continue
 		} else if err != nil {
 			log.Errorf("Could not determine whether update is allowed for %q: %s", cont.Container.Name, err)
 			continue
 		}
 
-		stopIt := false
-
-		if cs, err := ccfg.Checksum(); err == nil && cont.Checksum != "" && cs != cont.Checksum {
-			// Checksum mismatch: Ask it to go
-			log.Infof("Container %s has a configuration update.", cont.Container.Name)
-			stopIt = true
+		for _, r := range reasons {
+			log.Infof("Container %s has a %s.", cont.Container.Name, r)
 		}
-
-		if img := s.getImageByName(ccfg.Image + ":" + ccfg.Tag); img != nil && img.ID != cont.Container.Image {
-			// Image was renewed: Ask it to go
-			log.Infof("Container %s has a new image version.", cont.Container.Name)
+		if img != nil && img.ID != cont.Container.Image {
 			log.WithFields(log.Fields{
 				"image":     ccfg.Image + ":" + ccfg.Tag,
 				"container": cont.Container.Name,
 				"old":       cont.Container.Image,
 				"new":       img.ID,
 			}).Debugf("Image update")
-			stopIt = true
 		}
 
-		if stopIt {
-			go func(id string, ccfg *config.ContainerConfig, cont container) {
-				if err := s.stopContainerGraph(strings.TrimLeft(cont.Container.Name, "/"), true); err != nil {
-					log.Errorf("Unable to stop container %q: %s", cont.Container.Name, err)
-				}
-			}(id, ccfg, cont)
+		if ccfg.Replicas > 0 {
+			if rolling[cont.ConfigName] || !s.replicasReady(cont.ConfigName, ccfg) {
+				// Replicas are updated one after another
+				continue
+			}
+			rolling[cont.ConfigName] = true
 		}
+
+		if ccfg.UpdateStrategy == config.UpdateStrategyStartFirst {
+			go s.replaceContainer(cont.ConfigName, name, cont.Replica, pending.Target(), ccfg, cont.Container)
+			continue
+		}
+
+		go s.updateContainer(cont.ConfigName, name, ccfg, cont.Container)
 	}
+
+	s.clearPendingUpdates(updating)
+}
+
+// replicasReady checks whether all replicas of the configuration are
+// running and none of them is being updated. The caller needs to hold
+// the containers lock.
+func (s *scheduler) replicasReady(name string, ccfg *config.ContainerConfig) bool {
+	running := map[string]bool{}
+	for _, cont := range s.knownContainers {
+		if cont.Container.State.Running {
+			running[strings.TrimLeft(cont.Container.Name, "/")] = true
+		}
+	}
+
+	for _, instance := range ccfg.InstanceNames(name) {
+		if !running[instance] || s.isReplacing(instance) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// logPostponedUpdate informs about an update not allowed right now
+// including the time of the next update window. To prevent flooding the
+// log this is only done once per window.
+func (s *scheduler) logPostponedUpdate(name string, ccfg *config.ContainerConfig) {
+	s.lock(lockPostponed, true)
+	defer s.unlock(lockPostponed, true)
+
+	if next, ok := s.postponedUpdates[name]; ok && next.After(time.Now()) {
+		return
+	}
+
+	next, found := ccfg.NextUpdateAllowed(time.Now())
+	if !found {
+		log.Warnf("Container %s has a pending update but no update window within the next 31 days", name)
+		next = time.Now().Add(24 * time.Hour)
+	} else {
+		log.Infof("Container %s has a pending update, postponed until %s", name, next.Format(time.RFC3339))
+	}
+
+	s.postponedUpdates[name] = next
+}
+
+func (s *scheduler) stopTimedOutJobs() {
+	s.lock(lockConfig, false)
+	defer s.unlock(lockConfig, false)
+
+	s.lock(lockContainers, false)
+	defer s.unlock(lockContainers, false)
+
+	for id, cont := range s.knownContainers {
+		if !cont.Container.State.Running || !cont.IsScheduled || s.stopReason(id) != "" {
+			continue
+		}
+
+		ccfg, ok := s.config[cont.ConfigName]
+		if !ok || ccfg.MaxRuntimeDuration() == 0 {
+			continue
+		}
+
+		if time.Since(cont.Container.State.StartedAt) < ccfg.MaxRuntimeDuration() {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"container":   cont.Container.Name,
+			"started_at":  cont.Container.State.StartedAt,
+			"max_runtime": ccfg.MaxRuntime,
+		}).Errorf("Job timed out, killing it")
+		// Mark before stopping in the background to not stop it twice
+		s.markStopped(id, stopReasonTimeout)
+
+		go func(cont container, ccfg *config.ContainerConfig) {
+			if err := s.stopContainer(cont.Container, ccfg, stopReasonTimeout); err != nil {
+				log.Errorf("Unable to stop timed out container %q: %s", cont.Container.Name, err)
+			}
+		}(cont, ccfg)
+	}
+}
+
+// markStopped records why the dockermanager stopped the container
+func (s *scheduler) markStopped(id, reason string) {
+	s.lock(lockJobs, true)
+	defer s.unlock(lockJobs, true)
+
+	s.stopReasons[id] = reason
+}
+
+func (s *scheduler) unmarkStopped(id string) {
+	s.lock(lockJobs, true)
+	defer s.unlock(lockJobs, true)
+
+	delete(s.stopReasons, id)
+}
+
+// stopReason returns why the dockermanager stopped the container or an
+// empty string if it did not stop it
+func (s *scheduler) stopReason(id string) string {
+	s.lock(lockJobs, false)
+	defer s.unlock(lockJobs, false)
+
+	return s.stopReasons[id]
+}
+
+func stopTimeout(ccfg *config.ContainerConfig) uint {
+	return uint(math.Max(5, float64(ccfg.StopTimeout)))
 }
 
 func (s *scheduler) stopContainerGraph(name string, isBaseLevel bool) error {
@@ -509,19 +841,38 @@ func (s *scheduler) stopContainerGraph(name string, isBaseLevel bool) error {
 		return fmt.Errorf("No container configuration found")
 	}
 
-	dependingOnMe := []string{}
-	for n, c := range s.config {
-		if str.StringInSlice(name, c.GetDependencies()) {
-			dependingOnMe = append(dependingOnMe, n)
+	if err := s.stopDependingContainers(name); err != nil {
+		return err
+	}
+
+	for _, instance := range ccfg.InstanceNames(name) {
+		if err := s.stopInstance(instance, ccfg, stopReasonUpdate); err != nil {
+			return err
 		}
 	}
 
-	for _, d := range dependingOnMe {
-		if err := s.stopContainerGraph(d, false); err != nil {
+	return nil
+}
+
+// stopDependingContainers stops the container graphs of all containers
+// depending on the given configuration. The caller needs to hold the
+// config lock.
+func (s *scheduler) stopDependingContainers(name string) error {
+	for n := range s.config {
+		if !str.StringInSlice(name, s.config.GetConfigDependencies(n)) {
+			continue
+		}
+
+		if err := s.stopContainerGraph(n, false); err != nil {
 			return err
 		}
 	}
 
+	return nil
+}
+
+// stopInstance stops a single container by its name
+func (s *scheduler) stopInstance(name string, ccfg *config.ContainerConfig, reason string) error {
 	s.lock(lockContainers, false)
 	cont := s.getContainerByName(name)
 	s.unlock(lockContainers, false)
@@ -531,8 +882,38 @@ func (s *scheduler) stopContainerGraph(name string, isBaseLevel bool) error {
 		return nil
 	}
 
-	stopTime := uint(math.Max(5, float64(ccfg.StopTimeout)))
-	return s.client.StopContainer(cont.ID, stopTime)
+	return s.stopContainer(cont, ccfg, reason)
+}
+
+// stopContainer executes the pre_stop hooks and stops the container.
+// Every container stopped by the dockermanager needs to be stopped
+// through this to record the reason, jobs stopped on purpose are not
+// retried. Containers without configuration (removed from the config)
+// are stopped without hooks.
+func (s *scheduler) stopContainer(cont *docker.Container, ccfg *config.ContainerConfig, reason string) error {
+	s.markStopped(cont.ID, reason)
+
+	if err := s.runPreStopAndStop(cont, ccfg); err != nil {
+		// Not stopped, the next attempt records the reason again
+		s.unmarkStopped(cont.ID)
+		return err
+	}
+
+	return nil
+}
+
+func (s *scheduler) runPreStopAndStop(cont *docker.Container, ccfg *config.ContainerConfig) error {
+	if ccfg == nil {
+		return s.client.StopContainer(cont.ID, 30)
+	}
+
+	if cont.State.Running {
+		if err := s.runHooks("pre_stop", ccfg.Hooks.PreStop, cont, ccfg); err != nil {
+			return err
+		}
+	}
+
+	return s.client.StopContainer(cont.ID, stopTimeout(ccfg))
 }
 
 func (s *scheduler) startContainers() {
@@ -559,28 +940,101 @@ func (s *scheduler) startContainers() {
 			continue
 		}
 
-		if cont := s.getContainerByName(name); cont != nil && cont.State.Running {
+		initDone := s.initContainersDone(name, ccfg)
+
+		for _, index := range ccfg.InstanceIndexes() {
+			s.startInstance(name, index, ccfg, initDone)
+		}
+	}
+}
+
+// startInstance starts a single container of the configuration if it
+// is not already running
+func (s *scheduler) startInstance(name string, index int, ccfg *config.ContainerConfig, initDone bool) {
+	instance := config.InstanceName(name, index)
+
+	if s.isReplacing(instance) {
+		// Start-first update in progress, container is handled there
+		return
+	}
+
+	containerName := instance
+	if cont := s.getContainerByName(instance); cont != nil && cont.State.Running {
+		if ccfg.StartTimes == "" {
 			// Is already running
-			continue
-		} else if cont != nil && !cont.State.Running {
-			// Isn't running but still known and should be running so remove the old one
+			return
+		}
+
+		// Scheduled job is due but the previous run is still active
+		switch ccfg.Concurrency {
+		case config.ConcurrencyAllow:
+			containerName = fmt.Sprintf("%s-%d", name, time.Now().Unix())
+
+		case config.ConcurrencyReplace:
+			log.Infof("Job %q is still running, replacing it with a new run", name)
+			if err := s.stopContainer(cont, ccfg, stopReasonReplaced); err != nil {
+				log.Errorf("Unable to stop container %q: %s", cont.Name, err)
+				return
+			}
+			// The destroy event may arrive before the die event is handled
+			if stopped, err := s.inspectContainer(cont.ID); err != nil {
+				log.Errorf("Unable to record replaced run of %q: %s", cont.Name, err)
+			} else {
+				s.recordRun(cont.ID, stopped)
+			}
 			if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
 				ID: cont.ID,
 			}); err != nil {
 				log.Errorf("Unable to remove container %q: %s", cont.Name, err)
-				continue
+				return
 			}
-		}
+			removeSecrets(cont)
 
-		// Should be running and old versions were removed: Lets start stuff!
-		if err := bootContainer(name, ccfg); err != nil {
-			log.Errorf("Unable to execute container %q: %s", name, err)
-			continue
+		default:
+			skipped, err := ccfg.SkipRun()
+			if err != nil {
+				log.Errorf("Unable to update next run for container %q: %s", name, err)
+			}
+			if skipped {
+				log.Warnf("Job %q is still running, skipping this run", name)
+				s.saveScheduleState()
+			}
+			return
 		}
-
-		if err := ccfg.UpdateNextRun(); err != nil {
-			log.Errorf("Unable to update next run for container %q: %s", name, err)
+	} else if cont != nil && !cont.State.Running {
+		// Isn't running but still known and should be running so remove the old one
+		if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
+			ID: cont.ID,
+		}); err != nil {
+			log.Errorf("Unable to remove container %q: %s", cont.Name, err)
+			return
 		}
+		removeSecrets(cont)
+	}
+
+	if !initDone {
+		// Main container may only be started after the init containers succeeded
+		s.startInitContainers(name, ccfg)
+		return
+	}
+
+	// Should be running and old versions were removed: Lets start stuff!
+	cont, err := bootContainer(name, containerName, index, ccfg)
+	if err != nil {
+		log.Errorf("Unable to execute container %q: %s", instance, err)
+		return
+	}
+
+	if len(ccfg.Hooks.PostStart) > 0 {
+		go s.runHooks("post_start", ccfg.Hooks.PostStart, cont, ccfg)
+	}
+
+	if err := ccfg.MarkRun(time.Now()); err != nil {
+		log.Errorf("Unable to update next run for container %q: %s", name, err)
+	}
+
+	if ccfg.StartTimes != "" {
+		s.saveScheduleState()
 	}
 }
 
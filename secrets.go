@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+var secretsKey []byte
+
+func loadSecretsKey() error {
+	if cfg.SecretsKeyFile == "" {
+		return nil
+	}
+
+	key, err := ioutil.ReadFile(cfg.SecretsKeyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read secrets key: %s", err)
+	}
+
+	secretsKey = bytes.TrimSpace(key)
+	return nil
+}
+
+// loadSecretDigestKey reads the host-local key for the digests of the
+// secrets in the checksum from the state dir, creating it if missing
+func loadSecretDigestKey() error {
+	fn := path.Join(cfg.StateDir, "secret-digest.key")
+
+	key, err := ioutil.ReadFile(fn)
+	switch {
+	case os.IsNotExist(err):
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("Unable to generate secret digest key: %s", err)
+		}
+		if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+			return fmt.Errorf("Unable to create state dir: %s", err)
+		}
+		if err := ioutil.WriteFile(fn, key, 0600); err != nil {
+			return fmt.Errorf("Unable to write secret digest key: %s", err)
+		}
+	case err != nil:
+		return fmt.Errorf("Unable to read secret digest key: %s", err)
+	}
+
+	config.SetSecretDigestKey(key)
+	return nil
+}
+
+// materializeSecrets writes the secrets of the container into a
+// directory below the secrets dir and returns the bind mount to use
+// for the container. If the container has no secrets an empty string
+// is returned.
+func materializeSecrets(name string, secrets config.SecretsConfig) (string, error) {
+	if len(secrets.Files) == 0 {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(cfg.SecretsDir, 0700); err != nil {
+		return "", fmt.Errorf("Unable to create secrets dir: %s", err)
+	}
+
+	if !isTmpfs(cfg.SecretsDir) {
+		log.Warnf("Secrets dir %q is not on a tmpfs, secrets of %q will touch the disk", cfg.SecretsDir, name)
+	}
+
+	secretDir := path.Join(cfg.SecretsDir, name)
+	if err := os.RemoveAll(secretDir); err != nil {
+		return "", fmt.Errorf("Unable to clean secrets of %q: %s", name, err)
+	}
+	if err := os.Mkdir(secretDir, 0755); err != nil {
+		return "", fmt.Errorf("Unable to create secrets of %q: %s", name, err)
+	}
+
+	for _, s := range secrets.Files {
+		content, err := s.Content(secretsKey)
+		if err != nil {
+			return "", fmt.Errorf("Unable to resolve secret %q: %s", s.Name, err)
+		}
+
+		mode, err := s.FileMode()
+		if err != nil {
+			return "", err
+		}
+
+		fn := path.Join(secretDir, s.Name)
+		if err := ioutil.WriteFile(fn, content, mode); err != nil {
+			return "", fmt.Errorf("Unable to write secret %q: %s", s.Name, err)
+		}
+
+		// WriteFile respects the umask so enforce the configured mode
+		if err := os.Chmod(fn, mode); err != nil {
+			return "", fmt.Errorf("Unable to set mode of secret %q: %s", s.Name, err)
+		}
+
+		if uid, gid := s.Owner(); uid >= 0 || gid >= 0 {
+			if err := os.Chown(fn, uid, gid); err != nil {
+				return "", fmt.Errorf("Unable to set owner of secret %q: %s", s.Name, err)
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s:%s:ro", secretDir, secrets.MountPath()), nil
+}
+
+// removeSecretsDir removes the secrets written for a container which
+// could not be created or started
+func removeSecretsDir(name string) {
+	if err := os.RemoveAll(path.Join(cfg.SecretsDir, name)); err != nil {
+		log.Errorf("Unable to remove secrets of %q: %s", name, err)
+	}
+}
+
+// removeSecrets removes the secrets dirs mounted into the given
+// container. The dir is not necessarily named like the container as
+// containers replaced using start-first are renamed after creation.
+func removeSecrets(cont *docker.Container) {
+	for _, m := range cont.Mounts {
+		if path.Dir(m.Source) != path.Clean(cfg.SecretsDir) {
+			continue
+		}
+
+		if err := os.RemoveAll(m.Source); err != nil {
+			log.Errorf("Unable to remove secrets of %q: %s", cont.Name, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+const tmpfsMagic = 0x01021994
+
+func isTmpfs(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	return st.Type == tmpfsMagic
+}
@@ -0,0 +1,7 @@
+// +build !linux
+
+package main
+
+// isTmpfs is not able to detect tmpfs mounts on non-linux systems so
+// it does not emit warnings there
+func isTmpfs(dir string) bool { return true }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statusRun is a run of the job history including its evaluated status
+type statusRun struct {
+	jobRun
+	Status string `json:"status"`
+}
+
+// EnableStatusAPI serves the job history as JSON on the given address:
+// `/history` lists the runs of all jobs without their output,
+// `/history/<job>` contains the runs of the job including the output.
+func (s *scheduler) EnableStatusAPI(listen string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/history", s.handleHistoryStatus)
+	mux.HandleFunc("/history/", s.handleHistoryStatus)
+
+	go func() {
+		log.Errorf("Status API stopped: %s", http.ListenAndServe(listen, mux))
+	}()
+}
+
+func (s *scheduler) handleHistoryStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := strings.Trim(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+
+	s.lock(lockHistory, false)
+	defer s.unlock(lockHistory, false)
+
+	if s.history == nil {
+		http.Error(w, "Job history is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var result interface{}
+	if job == "" {
+		overview := map[string][]statusRun{}
+		for name, runs := range s.history {
+			for _, run := range runs {
+				run.Stdout, run.Stderr = "", ""
+				overview[name] = append(overview[name], statusRun{run, run.status()})
+			}
+		}
+		result = overview
+	} else {
+		runs, ok := s.history[job]
+		if !ok {
+			http.Error(w, "No runs recorded for this job", http.StatusNotFound)
+			return
+		}
+
+		details := []statusRun{}
+		for _, run := range runs {
+			details = append(details, statusRun{run, run.status()})
+		}
+		result = details
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Unable to write status response: %s", err)
+	}
+}
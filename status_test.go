@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryStatus(t *testing.T) {
+	s := &scheduler{locks: map[string]*sync.RWMutex{}, history: jobHistory{}}
+
+	started := time.Now().Add(-time.Minute)
+	s.history.add("backup", jobRun{ContainerID: "a", StartedAt: started, FinishedAt: time.Now(), ExitCode: 1, Stdout: "out"}, historyRetention{})
+	// The die event of a run recorded before its removal must not duplicate it
+	s.history.add("backup", jobRun{ContainerID: "a", StartedAt: started, FinishedAt: time.Now(), ExitCode: 1, Stdout: "out"}, historyRetention{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHistoryStatus(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	overview := map[string][]statusRun{}
+	if err := json.NewDecoder(get("/history").Body).Decode(&overview); err != nil {
+		t.Fatalf("Unable to decode overview: %s", err)
+	}
+	if runs := overview["backup"]; len(runs) != 1 || runs[0].Status != "failed" || runs[0].Stdout != "" {
+		t.Errorf("Unexpected overview: %+v", overview)
+	}
+
+	details := []statusRun{}
+	if err := json.NewDecoder(get("/history/backup").Body).Decode(&details); err != nil {
+		t.Fatalf("Unable to decode details: %s", err)
+	}
+	if len(details) != 1 || details[0].Stdout != "out" {
+		t.Errorf("Unexpected details: %+v", details)
+	}
+
+	if rec := get("/history/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown job returned status %d", rec.Code)
+	}
+}
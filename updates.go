@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	decisionApprove = "approve"
+	decisionReject  = "reject"
+)
+
+// pendingUpdate represents an update of a container with update_policy
+// notify or manual waiting for a decision
+type pendingUpdate struct {
+	Container   string    `json:"container"`
+	OldImage    string    `json:"old_image"`
+	NewImage    string    `json:"new_image"`
+	OldChecksum string    `json:"old_checksum"`
+	NewChecksum string    `json:"new_checksum"`
+	DetectedAt  time.Time `json:"detected_at"`
+	Approved    bool      `json:"approved,omitempty"`
+	Rejected    bool      `json:"rejected,omitempty"`
+}
+
+// Target identifies the state the container would be updated to
+func (p pendingUpdate) Target() string { return p.NewImage + "/" + p.NewChecksum }
+
+func (p pendingUpdate) status() string {
+	switch {
+	case p.Approved:
+		return "approved"
+	case p.Rejected:
+		return "rejected"
+	}
+	return "pending"
+}
+
+type updateDecision struct {
+	Decision string `json:"decision"`
+	Target   string `json:"target"`
+}
+
+type pendingUpdates map[string]pendingUpdate
+
+func loadPendingUpdates(filename string) (pendingUpdates, error) {
+	updates := pendingUpdates{}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return updates, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read pending updates: %s", err)
+	}
+
+	if err := json.Unmarshal(data, &updates); err != nil {
+		return nil, fmt.Errorf("Unable to parse pending updates: %s", err)
+	}
+
+	return updates, nil
+}
+
+func (p pendingUpdates) save(filename string) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal pending updates: %s", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return fmt.Errorf("Unable to create state dir: %s", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write pending updates: %s", err)
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// EnableUpdateApproval activates the notify and manual update policies.
+// Pending updates are stored in the given file, decisions are read from
+// the decisions dir. Updates of the notify policy are executed after the
+// notify delay unless they were rejected.
+func (s *scheduler) EnableUpdateApproval(filename, decisionsDir string, notifyDelay time.Duration) error {
+	s.lock(lockUpdates, true)
+	defer s.unlock(lockUpdates, true)
+
+	updates, err := loadPendingUpdates(filename)
+	if err != nil {
+		return err
+	}
+
+	s.pendingUpdates = updates
+	s.pendingUpdatesFile = filename
+	s.decisionsDir = decisionsDir
+	s.notifyDelay = notifyDelay
+
+	return nil
+}
+
+// updateApproved checks whether the update of the container may be
+// executed according to its update_policy. For the notify and manual
+// policies the update is recorded as pending. Updates of the notify
+// policy are executed after the notify delay unless they are rejected,
+// updates of the manual policy need to be approved.
+func (s *scheduler) updateApproved(name string, ccfg *config.ContainerConfig, pending pendingUpdate) bool {
+	if ccfg.UpdatePolicy == "" || ccfg.UpdatePolicy == config.UpdatePolicyAuto {
+		return true
+	}
+
+	s.lock(lockUpdates, true)
+	defer s.unlock(lockUpdates, true)
+
+	if s.pendingUpdatesFile == "" {
+		log.Errorf("Container %s has update_policy %q but update approval is not available", name, ccfg.UpdatePolicy)
+		return false
+	}
+
+	previous, ok := s.pendingUpdates[name]
+	known := previous
+	if !ok || known.Target() != pending.Target() {
+		pending.DetectedAt = time.Now()
+		known = pending
+
+		logger := log.WithFields(log.Fields{
+			"container": name,
+			"old_image": pending.OldImage,
+			"new_image": pending.NewImage,
+		})
+		if ccfg.UpdatePolicy == config.UpdatePolicyNotify {
+			logger.Warnf("Container has a pending update, it is executed at %s unless it gets rejected",
+				pending.DetectedAt.Add(s.notifyDelay).Format(time.RFC3339))
+		} else {
+			logger.Infof("Container has a pending update waiting for approval")
+		}
+	}
+
+	if decision, err := s.readUpdateDecision(name); err != nil {
+		log.Errorf("Unable to read update decision for %s: %s", name, err)
+	} else if decision != nil && decision.Target == known.Target() {
+		known.Approved = decision.Decision == decisionApprove
+		known.Rejected = decision.Decision == decisionReject
+		log.Infof("Pending update of container %s was %s", name, known.status())
+	}
+
+	if !ok || known != previous {
+		s.pendingUpdates[name] = known
+		if err := s.pendingUpdates.save(s.pendingUpdatesFile); err != nil {
+			log.Errorf("Unable to store pending updates: %s", err)
+		}
+	}
+
+	if ccfg.UpdatePolicy == config.UpdatePolicyNotify {
+		return known.Approved || (!known.Rejected && time.Since(known.DetectedAt) >= s.notifyDelay)
+	}
+	return known.Approved
+}
+
+// clearPendingUpdates removes the pending updates of all containers
+// which no longer have an update to be executed
+func (s *scheduler) clearPendingUpdates(updating map[string]bool) {
+	s.lock(lockUpdates, true)
+	defer s.unlock(lockUpdates, true)
+
+	changed := false
+	for name := range s.pendingUpdates {
+		if !updating[name] {
+			delete(s.pendingUpdates, name)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	if err := s.pendingUpdates.save(s.pendingUpdatesFile); err != nil {
+		log.Errorf("Unable to store pending updates: %s", err)
+	}
+}
+
+// readUpdateDecision reads and removes the decision file for the container
+func (s *scheduler) readUpdateDecision(name string) (*updateDecision, error) {
+	fn := path.Join(s.decisionsDir, name)
+
+	data, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(fn); err != nil {
+		return nil, err
+	}
+
+	d := &updateDecision{}
+	return d, json.Unmarshal(data, d)
+}
+
+// printPendingUpdates implements the `updates` command
+func printPendingUpdates(filename string) error {
+	updates, err := loadPendingUpdates(filename)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range updates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-25s %-10s %-20s %-19s %s\n", "CONTAINER", "STATUS", "DETECTED", "NEW IMAGE", "CONFIG CHANGED")
+	for _, name := range names {
+		u := updates[name]
+		fmt.Printf("%-25s %-10s %-20s %-19s %t\n",
+			name, u.status(), u.DetectedAt.Local().Format("2006-01-02 15:04:05"),
+			shortID(u.NewImage), u.OldChecksum != u.NewChecksum)
+	}
+
+	return nil
+}
+
+// decideUpdate implements the `approve` and `reject` commands
+func decideUpdate(filename, decisionsDir, decision string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Exactly one container name is required")
+	}
+
+	updates, err := loadPendingUpdates(filename)
+	if err != nil {
+		return err
+	}
+
+	update, ok := updates[args[0]]
+	if !ok {
+		return fmt.Errorf("Container %q has no pending update", args[0])
+	}
+
+	data, err := json.Marshal(updateDecision{Decision: decision, Target: update.Target()})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(decisionsDir, 0700); err != nil {
+		return fmt.Errorf("Unable to create decisions dir: %s", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(decisionsDir, args[0]), data, 0600); err != nil {
+		return fmt.Errorf("Unable to write decision: %s", err)
+	}
+
+	fmt.Printf("Update of %s will be %sd with the next check of the dockermanager\n", args[0], decision)
+	return nil
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
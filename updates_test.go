@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/dockermanager/config"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestUpdateApproved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updates")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		stateFile    = path.Join(dir, "pending-updates.json")
+		decisionsDir = path.Join(dir, "decisions")
+		pending      = pendingUpdate{Container: "web", OldImage: "sha256:old", NewImage: "sha256:new"}
+	)
+
+	s := &scheduler{locks: map[string]*sync.RWMutex{}}
+	if err := s.EnableUpdateApproval(stateFile, decisionsDir, time.Hour); err != nil {
+		t.Fatalf("Unable to enable update approval: %s", err)
+	}
+
+	decide := func(decision string) {
+		if err := decideUpdate(stateFile, decisionsDir, decision, []string{"web"}); err != nil {
+			t.Fatalf("Unable to %s update: %s", decision, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		decision string
+		approved bool
+	}{
+		{name: "auto", policy: config.UpdatePolicyAuto, approved: true},
+		{name: "notify", policy: config.UpdatePolicyNotify, approved: false},
+		{name: "notify approved", policy: config.UpdatePolicyNotify, decision: decisionApprove, approved: true},
+		{name: "notify rejected", policy: config.UpdatePolicyNotify, decision: decisionReject, approved: false},
+		{name: "manual", policy: config.UpdatePolicyManual, approved: false},
+		{name: "manual approved", policy: config.UpdatePolicyManual, decision: decisionApprove, approved: true},
+	} {
+		s.pendingUpdates = pendingUpdates{}
+		os.Remove(stateFile)
+
+		ccfg := &config.ContainerConfig{UpdatePolicy: tc.policy}
+		if tc.decision != "" {
+			s.updateApproved("web", ccfg, pending)
+			decide(tc.decision)
+		}
+
+		if approved := s.updateApproved("web", ccfg, pending); approved != tc.approved {
+			t.Errorf("%s: got approved = %v, expected %v", tc.name, approved, tc.approved)
+		}
+
+		_, err := os.Stat(stateFile)
+		if written := err == nil; written != (tc.policy != config.UpdatePolicyAuto) {
+			t.Errorf("%s: pending update written = %v", tc.name, written)
+		}
+
+		// Unchanged pending updates must not be written again
+		os.Remove(stateFile)
+		s.updateApproved("web", ccfg, pending)
+		if _, err := os.Stat(stateFile); err == nil {
+			t.Errorf("%s: unchanged pending update was written again", tc.name)
+		}
+	}
+}
+
+func TestNotifyUpdateNotStoppedOnDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updates")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		stops     = make(chan string, 10)
+		dockerAPI = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/stop") {
+				stops <- r.URL.Path
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	)
+	defer dockerAPI.Close()
+
+	client, err := docker.NewClient(dockerAPI.URL)
+	if err != nil {
+		t.Fatalf("Unable to create docker client: %s", err)
+	}
+
+	ccfg := &config.ContainerConfig{Hosts: []string{"ALL"}, Image: "nginx", Tag: "latest", UpdatePolicy: config.UpdatePolicyNotify}
+	s := &scheduler{
+		client:          client,
+		config:          config.Config{"web": ccfg},
+		knownContainers: map[string]container{},
+		stopReasons:     map[string]string{},
+		replacing:       map[string]bool{},
+		locks:           map[string]*sync.RWMutex{},
+	}
+	s.knownContainers["abc"] = container{
+		Checksum:   "outdated",
+		ConfigName: "web",
+		IsManaged:  true,
+		Container: &docker.Container{
+			ID:    "abc",
+			Name:  "/web",
+			Image: "sha256:old",
+			State: docker.State{Running: true},
+		},
+	}
+
+	if err := s.EnableUpdateApproval(path.Join(dir, "pending-updates.json"), path.Join(dir, "decisions"), time.Hour); err != nil {
+		t.Fatalf("Unable to enable update approval: %s", err)
+	}
+
+	s.stopContainersWithUpdates()
+	select {
+	case p := <-stops:
+		t.Fatalf("Container was stopped on the first detection: %s", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, ok := s.pendingUpdates["web"]; !ok {
+		t.Fatalf("Pending update was not recorded")
+	}
+
+	// After the delay the update is executed
+	p := s.pendingUpdates["web"]
+	p.DetectedAt = time.Now().Add(-2 * time.Hour)
+	s.pendingUpdates["web"] = p
+
+	s.stopContainersWithUpdates()
+	select {
+	case <-stops:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Container was not stopped after the notify delay")
+	}
+}